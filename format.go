@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/vatine/planning/models"
+)
+
+// Formats model files in place. With check set, files are left alone
+// and the names of unformatted files are printed instead. Returns
+// false if any file could not be formatted, or was not formatted
+// when checking.
+func formatFiles(files []string, check bool) bool {
+	ok := true
+	for _, filename := range files {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Printf("Error reading %s, %s\n", filename, err)
+			ok = false
+			continue
+		}
+		formatted, err := models.Format(data)
+		if err != nil {
+			fmt.Printf("Error formatting %s, %s\n", filename, err)
+			ok = false
+			continue
+		}
+		if bytes.Equal(data, formatted) {
+			continue
+		}
+		if check {
+			fmt.Println(filename)
+			ok = false
+			continue
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			fmt.Printf("Error reading %s, %s\n", filename, err)
+			ok = false
+			continue
+		}
+		if err := ioutil.WriteFile(filename, formatted, info.Mode()); err != nil {
+			fmt.Printf("Error writing %s, %s\n", filename, err)
+			ok = false
+		}
+	}
+	return ok
+}
@@ -0,0 +1,242 @@
+// Compiling graphs for fast, repeated, evaluation
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// An expression compiled to a closure, reading inputs and variables
+// from slots.
+type compiledExpr func(slots []float64) float64
+
+// A single step of a compiled graph, setting a slot or, for outputs
+// feeding an input, adding to it.
+type instruction struct {
+	slot     int
+	expr     compiledExpr
+	add      bool
+	variable bool
+}
+
+// The compiled resource expressions of a single model
+type compiledModel struct {
+	name     string
+	policy   Policy
+	replicas compiledExpr
+	ram      compiledExpr
+	cpu      compiledExpr
+}
+
+// A graph compiled for fast evaluation of point values. Every input
+// and variable is resolved to a slot when compiling, so evaluating
+// involves no map lookups or interface dispatch. Ranges are not
+// computed, and distributions evaluate to their mean. Variables maps
+// every variable that can be overridden to its slot.
+type Compiled struct {
+	top       string
+	slots     map[string]int
+	variables map[string]int
+	program   []instruction
+	models    []compiledModel
+}
+
+// Compiles an expression, using resolve to find the slot of every
+// referenced name.
+func compile(e Expression, resolve func(string) (int, error)) (compiledExpr, error) {
+	switch x := e.(type) {
+	case constant, span, distribution:
+		v := x.Value(Model{})
+		return func([]float64) float64 { return v }, nil
+	case reference:
+		slot, err := resolve(x.name)
+		if err != nil {
+			return nil, err
+		}
+		return func(s []float64) float64 { return s[slot] }, nil
+	case variable:
+		return compile(reference{x.name}, resolve)
+	case operation:
+		l, err := compile(x.left, resolve)
+		if err != nil {
+			return nil, err
+		}
+		r, err := compile(x.right, resolve)
+		if err != nil {
+			return nil, err
+		}
+		switch x.operator {
+		case "+":
+			return func(s []float64) float64 { return l(s) + r(s) }, nil
+		case "-":
+			return func(s []float64) float64 { return l(s) - r(s) }, nil
+		case "*":
+			return func(s []float64) float64 { return l(s) * r(s) }, nil
+		case "/":
+			return func(s []float64) float64 { return l(s) / r(s) }, nil
+		}
+		return nil, errors.New(fmt.Sprintf("Unknown operator %s", x.operator))
+	}
+	return nil, errors.New(fmt.Sprintf("Cannot compile %v", e))
+}
+
+// Returns the variables of a model in an order where every variable
+// comes after the variables it refers to.
+func variableOrder(m *Model) ([]string, error) {
+	deps := make(map[string][]string)
+	for name, v := range m.Variables {
+		deps[name] = []string{}
+		for _, ref := range references(v.expr) {
+			_, isInput := m.Inputs[ref]
+			if _, isVariable := m.Variables[ref]; isVariable && !isInput {
+				deps[name] = append(deps[name], ref)
+			}
+		}
+	}
+	order, err := topoSort(deps)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Circular variables in model %s", m.Name))
+	}
+	return order, nil
+}
+
+// Compiles the graph. Fails if any expression refers to a name that
+// is neither an input nor a variable of its model, or if the variables
+// of a model refer to each other in a circle.
+func (g *Graph) Compile() (*Compiled, error) {
+	c := Compiled{top: g.top, slots: make(map[string]int), variables: make(map[string]int)}
+	for _, name := range g.order {
+		m := g.models[name]
+		for input, _ := range m.Inputs {
+			c.slots[name+"."+input] = len(c.slots)
+		}
+		// A variable named like an input is never read, so can be
+		// overridden to no effect, as when evaluating the graph
+		for variable, _ := range m.Variables {
+			c.variables[name+"."+variable] = -1
+			if _, ok := m.Inputs[variable]; !ok {
+				c.variables[name+"."+variable] = len(c.slots)
+				c.slots[name+"."+variable] = len(c.slots)
+			}
+		}
+	}
+
+	for _, name := range g.order {
+		m := g.models[name]
+		resolve := func(ref string) (int, error) {
+			slot, ok := c.slots[m.Name+"."+ref]
+			if !ok {
+				return 0, errors.New(fmt.Sprintf("Unknown reference %s in model %s", ref, m.Name))
+			}
+			return slot, nil
+		}
+
+		order, err := variableOrder(m)
+		if err != nil {
+			return nil, err
+		}
+		for _, variable := range order {
+			if _, ok := m.Inputs[variable]; ok {
+				continue
+			}
+			expr, err := compile(m.Variables[variable].expr, resolve)
+			if err != nil {
+				return nil, err
+			}
+			c.program = append(c.program, instruction{slot: c.slots[name+"."+variable], expr: expr, variable: true})
+		}
+		for _, o := range m.Outputs {
+			expr, err := compile(o.value, resolve)
+			if err != nil {
+				return nil, err
+			}
+			c.program = append(c.program, instruction{slot: c.slots[o.backend+"."+o.input], expr: expr, add: true})
+		}
+	}
+
+	for _, name := range g.Names() {
+		m := g.models[name]
+		resolve := func(ref string) (int, error) {
+			slot, ok := c.slots[m.Name+"."+ref]
+			if !ok {
+				return 0, errors.New(fmt.Sprintf("Unknown reference %s in model %s", ref, m.Name))
+			}
+			return slot, nil
+		}
+		cm := compiledModel{name: name, policy: m.Policy}
+		exprs := map[string]*compiledExpr{"replicas": &cm.replicas, "ram": &cm.ram, "cpu": &cm.cpu}
+		for resource, dst := range exprs {
+			e, ok := m.Resources[resource]
+			if !ok {
+				e = constant{0}
+				if resource == "replicas" {
+					e = constant{1}
+				}
+			}
+			expr, err := compile(e, resolve)
+			if err != nil {
+				return nil, err
+			}
+			*dst = expr
+		}
+		c.models = append(c.models, cm)
+	}
+
+	return &c, nil
+}
+
+// Evaluates the compiled graph with the given top-level inputs and
+// overridden variables, keyed by "model.variable". Returns the results
+// of all models, sorted by name. Ranges in the results only contain
+// the point values. Like Graph.Run, fails if an override is not a
+// variable.
+func (c *Compiled) Evaluate(inputs map[string]float64, overrides map[string]float64) ([]Result, error) {
+	slots := make([]float64, len(c.slots))
+	fixed := make([]bool, len(c.slots))
+	for name, v := range inputs {
+		slot, ok := c.slots[c.top+"."+name]
+		if !ok {
+			continue
+		}
+		slots[slot] += v
+	}
+	for target, v := range overrides {
+		slot, ok := c.variables[target]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Cannot override %s, no such variable", target))
+		}
+		if slot < 0 {
+			continue
+		}
+		slots[slot] = v
+		fixed[slot] = true
+	}
+
+	for _, ins := range c.program {
+		switch {
+		case ins.add:
+			slots[ins.slot] += ins.expr(slots)
+		case ins.variable && fixed[ins.slot]:
+			continue
+		default:
+			slots[ins.slot] = ins.expr(slots)
+		}
+	}
+
+	rv := make([]Result, len(c.models))
+	for ix, m := range c.models {
+		r := Result{Model: m.name}
+		raw := m.replicas(slots)
+		r.Replicas = m.policy.apply(raw)
+		r.RawReplicas = math.Ceil(raw)
+		r.RAM = m.ram(slots)
+		r.CPU = m.cpu(slots)
+		r.ReplicasRange = point(r.Replicas)
+		r.RAMRange = point(r.RAM)
+		r.CPURange = point(r.CPU)
+		rv[ix] = r
+	}
+	return rv, nil
+}
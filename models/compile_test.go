@@ -0,0 +1,121 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestCompiledMatchesRun(t *testing.T) {
+	chained := graphModels()
+	back := chained["back"]
+	// Variables referring to each other, in reverse alphabetical order
+	back.Variables["a"] = newVariable("a", operation{"+", reference{"b"}, constant{1}})
+	back.Variables["b"] = newVariable("b", operation{"-", reference{"doubled"}, span{10, 30}})
+	back.Resources["ram"] = operation{"*", reference{"a"}, constant{0.5}}
+	back.Variables["cores"] = newVariable("cores", distribution{"normal", []float64{4, 1}})
+	back.Resources["cpu"] = reference{"cores"}
+	withPolicy := graphModels()
+	withPolicy["back"].Policy = Policy{Redundancy: "n+2", TargetUtilization: 0.7, MinReplicas: 50}
+
+	td := []struct {
+		models    map[string]*Model
+		top       string
+		inputs    map[string]float64
+		overrides map[string]float64
+	}{
+		{graphModels(), "front", map[string]float64{"qps": 1000}, nil},
+		{chained, "front", map[string]float64{"qps": 1000}, nil},
+		{chained, "front", map[string]float64{"qps": 1000}, map[string]float64{"back.b": 7}},
+		{wideModels(4, 10), "top", map[string]float64{"qps": 10000}, nil},
+		{withPolicy, "front", map[string]float64{"qps": 1000}, nil},
+		{withPolicy, "front", map[string]float64{"qps": 100}, nil},
+	}
+
+	for ix, d := range td {
+		g, err := NewGraph(d.models, d.top)
+		if err != nil {
+			t.Fatalf("test %d, unexpected error, %s", ix, err)
+		}
+		c, err := g.Compile()
+		if err != nil {
+			t.Fatalf("test %d, unexpected error compiling, %s", ix, err)
+		}
+		s := Scenario{Inputs: map[string]Expression{}, Overrides: map[string]Expression{}}
+		for name, v := range d.inputs {
+			s.Inputs[name] = constant{v}
+		}
+		for target, v := range d.overrides {
+			s.Overrides[target] = constant{v}
+		}
+		run, err := g.Run(s)
+		if err != nil {
+			t.Fatalf("test %d, unexpected error, %s", ix, err)
+		}
+		seen, err := c.Evaluate(d.inputs, d.overrides)
+		if err != nil {
+			t.Fatalf("test %d, unexpected error, %s", ix, err)
+		}
+		expected := run.Results()
+		if len(seen) != len(expected) {
+			t.Fatalf("test %d, saw %d results, expected %d", ix, len(seen), len(expected))
+		}
+		for rIx, e := range expected {
+			r := seen[rIx]
+			if r.Model != e.Model || r.Replicas != e.Replicas || r.RawReplicas != e.RawReplicas || r.RAM != e.RAM || r.CPU != e.CPU {
+				t.Errorf("test %d, saw %v, expected %v", ix, r, e)
+			}
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	unknown := graphModels()
+	unknown["back"].Resources["ram"] = reference{"nope"}
+	circular := graphModels()
+	circular["back"].Variables["a"] = newVariable("a", reference{"b"})
+	circular["back"].Variables["b"] = newVariable("b", reference{"a"})
+
+	// Graphs are compiled when created, so neither gets that far
+	for ix, models := range []map[string]*Model{unknown, circular} {
+		if _, err := NewGraph(models, "front"); err == nil {
+			t.Errorf("test %d, expected an error creating the graph", ix)
+		}
+	}
+
+	g, _ := NewGraph(graphModels(), "front")
+	c, _ := g.Compile()
+	// An input is not a variable, for either evaluator
+	for _, target := range []string{"back.nope", "back.qps", "back"} {
+		if _, err := c.Evaluate(nil, map[string]float64{target: 1}); err == nil {
+			t.Errorf("Expected an error overriding %s", target)
+		}
+		if _, err := g.Run(Scenario{Overrides: map[string]Expression{target: constant{1}}}); err == nil {
+			t.Errorf("Expected an error overriding %s in the graph", target)
+		}
+	}
+}
+
+func BenchmarkTreeEvaluate(b *testing.B) {
+	g, _ := NewGraph(wideModels(10, 20), "top")
+	s := Scenario{Inputs: map[string]Expression{"qps": constant{10000}}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := g.Run(s); err != nil {
+			b.Fatalf("Unexpected error, %s", err)
+		}
+	}
+}
+
+func BenchmarkCompiledEvaluate(b *testing.B) {
+	g, _ := NewGraph(wideModels(10, 20), "top")
+	c, err := g.Compile()
+	if err != nil {
+		b.Fatalf("Unexpected error, %s", err)
+	}
+	inputs := map[string]float64{"qps": 10000}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Evaluate(inputs, nil); err != nil {
+			b.Fatalf("Unexpected error, %s", err)
+		}
+	}
+}
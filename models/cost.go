@@ -0,0 +1,112 @@
+// Monthly cost of the resources used by models
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Hours in an average month, for turning hourly prices into monthly
+// costs.
+const HoursPerMonth = 730
+
+const bytesPerGiB = 1024 * 1024 * 1024
+
+// Prices per resource unit. RAM and disk are given to models in
+// bytes, but priced per GiB. Disk comes from the "disk" resource of a
+// model, and IOPS from its "iops" resource, both per replica.
+type Pricing struct {
+	CPUCoreHour  float64 `yaml:"cpu_core_hour"`
+	RAMGiBHour   float64 `yaml:"ram_gib_hour"`
+	DiskGiBMonth float64 `yaml:"disk_gib_month"`
+	IOPSMonth    float64 `yaml:"iops_month"`
+	InstanceHour float64 `yaml:"instance_hour"`
+}
+
+// The monthly cost of a model, or of all models, by resource.
+type Cost struct {
+	CPU       float64 `json:"cpu"`
+	RAM       float64 `json:"ram"`
+	Disk      float64 `json:"disk"`
+	IOPS      float64 `json:"iops"`
+	Instances float64 `json:"instances"`
+	Total     float64 `json:"total"`
+}
+
+// Parses a pricing file. Unknown keys are an error, as they are most
+// likely misspelt prices.
+func ParsePricing(data []byte) (Pricing, error) {
+	rv := Pricing{}
+	if err := yaml.UnmarshalStrict(data, &rv); err != nil {
+		return rv, errors.New(fmt.Sprintf("Bad pricing, %s", err))
+	}
+	return rv, nil
+}
+
+// Loads a pricing file, see ParsePricing.
+func LoadPricing(filename string) (Pricing, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return Pricing{}, err
+	}
+	return ParsePricing(data)
+}
+
+// Returns a resource of an evaluated model across all its replicas,
+// or 0 if the model does not use it.
+func allResource(m *Model, resource string) float64 {
+	e, ok := m.Resources[resource]
+	if !ok {
+		return 0
+	}
+	return e.Value(*m) * replicaCount(m)
+}
+
+// Returns the monthly cost of an evaluated model
+func (p Pricing) ModelCost(m *Model) Cost {
+	rv := Cost{
+		CPU:       allCPU(m) * p.CPUCoreHour * HoursPerMonth,
+		RAM:       allRAM(m) / bytesPerGiB * p.RAMGiBHour * HoursPerMonth,
+		Disk:      allResource(m, "disk") / bytesPerGiB * p.DiskGiBMonth,
+		IOPS:      allResource(m, "iops") * p.IOPSMonth,
+		Instances: replicaCount(m) * p.InstanceHour * HoursPerMonth,
+	}
+	rv.Total = rv.CPU + rv.RAM + rv.Disk + rv.IOPS + rv.Instances
+	return rv
+}
+
+// Returns the sum of two costs
+func (c Cost) Add(o Cost) Cost {
+	return Cost{c.CPU + o.CPU, c.RAM + o.RAM, c.Disk + o.Disk, c.IOPS + o.IOPS, c.Instances + o.Instances, c.Total + o.Total}
+}
+
+// Returns the total monthly cost of all evaluated models
+func (p Pricing) TotalCost(models map[string]*Model) Cost {
+	rv := Cost{}
+	for _, m := range models {
+		rv = rv.Add(p.ModelCost(m))
+	}
+	return rv
+}
+
+// Prints a monthly cost, with the resources that contribute to it.
+func printCost(w io.Writer, indent string, c Cost) {
+	fmt.Fprintf(w, "%scost: # per month\n", indent)
+	parts := []struct {
+		name string
+		v    float64
+	}{
+		{"cpu", c.CPU}, {"ram", c.RAM}, {"disk", c.Disk}, {"iops", c.IOPS}, {"instances", c.Instances},
+	}
+	for _, part := range parts {
+		if part.v != 0 {
+			fmt.Fprintf(w, "%s  %s: %.2f\n", indent, part.name, part.v)
+		}
+	}
+	fmt.Fprintf(w, "%s  total: %.2f\n", indent, c.Total)
+}
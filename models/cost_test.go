@@ -0,0 +1,64 @@
+package models
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParsePricing(t *testing.T) {
+	p, err := ParsePricing([]byte("cpu_core_hour: 0.04\nram_gib_hour: 0.005\ninstance_hour: 0.01\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if p != (Pricing{CPUCoreHour: 0.04, RAMGiBHour: 0.005, InstanceHour: 0.01}) {
+		t.Errorf("Unexpected pricing %v", p)
+	}
+	if _, err := ParsePricing([]byte("cpu_core_hours: 0.04\n")); err == nil {
+		t.Errorf("Expected an error for an unknown price")
+	}
+}
+
+func TestModelCost(t *testing.T) {
+	m := New("store")
+	m.Resources["replicas"] = constant{3}
+	m.Resources["cpu"] = constant{2}
+	m.Resources["ram"] = constant{4 * bytesPerGiB}
+	m.Resources["disk"] = constant{100 * bytesPerGiB}
+	m.Resources["iops"] = constant{500}
+	p := Pricing{CPUCoreHour: 0.01, RAMGiBHour: 0.001, DiskGiBMonth: 0.1, IOPSMonth: 0.002, InstanceHour: 0.1}
+
+	c := p.ModelCost(m)
+	expected := Cost{
+		CPU:       6 * 0.01 * HoursPerMonth,
+		RAM:       12 * 0.001 * HoursPerMonth,
+		Disk:      300 * 0.1,
+		IOPS:      1500 * 0.002,
+		Instances: 3 * 0.1 * HoursPerMonth,
+	}
+	expected.Total = expected.CPU + expected.RAM + expected.Disk + expected.IOPS + expected.Instances
+	seen := []float64{c.CPU, c.RAM, c.Disk, c.IOPS, c.Instances, c.Total}
+	for ix, v := range []float64{expected.CPU, expected.RAM, expected.Disk, expected.IOPS, expected.Instances, expected.Total} {
+		if math.Abs(seen[ix]-v) > 1e-9 {
+			t.Errorf("Saw cost %v, expected %v", c, expected)
+			break
+		}
+	}
+
+	total := p.TotalCost(map[string]*Model{"a": m, "b": m})
+	if math.Abs(total.Total-2*expected.Total) > 1e-9 {
+		t.Errorf("Saw total cost %f, expected %f", total.Total, 2*expected.Total)
+	}
+
+	var buf bytes.Buffer
+	PrintModel(&buf, m, &p)
+	if !strings.Contains(buf.String(), "  cost: # per month\n    cpu: 43.80\n") {
+		t.Errorf("Expected the cost in the printed model, saw\n%s", buf.String())
+	}
+	buf.Reset()
+	PrintModel(&buf, m, nil)
+	if strings.Contains(buf.String(), "cost") {
+		t.Errorf("Expected no cost without pricing, saw\n%s", buf.String())
+	}
+}
@@ -0,0 +1,124 @@
+// Probability distributions, for Monte Carlo evaluation
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// Number of parameters taken by each supported distribution
+var distributionParams = map[string]int{
+	"normal":     2, // mean, standard deviation
+	"lognormal":  2, // mean and standard deviation of the logarithm
+	"uniform":    2, // min, max
+	"triangular": 3, // min, mode, max
+}
+
+// A value drawn from a probability distribution, like normal(500, 50).
+// When evaluated directly, a distribution takes its mean value.
+type distribution struct {
+	kind   string
+	params []float64
+}
+
+func (d distribution) Value(m Model) float64 {
+	p := d.params
+	switch d.kind {
+	case "normal":
+		return p[0]
+	case "lognormal":
+		return math.Exp(p[0] + p[1]*p[1]/2)
+	case "uniform":
+		return (p[0] + p[1]) / 2
+	case "triangular":
+		return (p[0] + p[1] + p[2]) / 3
+	}
+	return -100000.0
+}
+
+// The range of a normal distribution is taken to be three standard
+// deviations either side of the mean.
+func (d distribution) Range(m Model) Interval {
+	p := d.params
+	switch d.kind {
+	case "normal":
+		return Interval{p[0] - 3*p[1], p[0] + 3*p[1]}
+	case "lognormal":
+		return Interval{math.Exp(p[0] - 3*p[1]), math.Exp(p[0] + 3*p[1])}
+	case "uniform":
+		return Interval{p[0], p[1]}
+	case "triangular":
+		return Interval{p[0], p[2]}
+	}
+	return point(-100000.0)
+}
+
+// Draws a single sample from the distribution.
+func (d distribution) Sample(r *rand.Rand) float64 {
+	p := d.params
+	switch d.kind {
+	case "normal":
+		return p[0] + p[1]*r.NormFloat64()
+	case "lognormal":
+		return math.Exp(p[0] + p[1]*r.NormFloat64())
+	case "uniform":
+		return p[0] + (p[1]-p[0])*r.Float64()
+	case "triangular":
+		lo, mode, hi := p[0], p[1], p[2]
+		u := r.Float64()
+		if hi == lo {
+			return lo
+		}
+		cut := (mode - lo) / (hi - lo)
+		if u < cut {
+			return lo + math.Sqrt(u*(hi-lo)*(mode-lo))
+		}
+		return hi - math.Sqrt((1-u)*(hi-lo)*(hi-mode))
+	}
+	return -100000.0
+}
+
+// Parses a distribution, like "normal(500, 50)". The second return
+// value is false if the string does not look like a distribution at
+// all.
+func parseDistribution(s string) (Expression, bool, error) {
+	s = strings.TrimSpace(s)
+	open := strings.Index(s, "(")
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		return nil, false, nil
+	}
+	kind := strings.TrimSpace(s[:open])
+	count, ok := distributionParams[kind]
+	if !ok {
+		return nil, false, nil
+	}
+
+	args := strings.Split(s[open+1:len(s)-1], ",")
+	if len(args) != count {
+		return nil, true, errors.New(fmt.Sprintf("%s takes %d parameters, saw %d", kind, count, len(args)))
+	}
+	params := []float64{}
+	for _, arg := range args {
+		var v float64
+		cnt, err := fmt.Sscan(strings.TrimSpace(arg), &v)
+		if cnt != 1 || err != nil {
+			return nil, true, errors.New(fmt.Sprintf("Bad parameter %q for %s", arg, kind))
+		}
+		params = append(params, v)
+	}
+
+	switch {
+	case (kind == "normal" || kind == "lognormal") && params[1] < 0:
+		return nil, true, errors.New(fmt.Sprintf("Negative standard deviation in %s", s))
+	case kind == "uniform" && params[0] > params[1]:
+		return nil, true, errors.New(fmt.Sprintf("Minimum above maximum in %s", s))
+	case kind == "triangular" && (params[0] > params[1] || params[1] > params[2]):
+		return nil, true, errors.New(fmt.Sprintf("Mode outside of range in %s", s))
+	}
+
+	return distribution{kind, params}, true, nil
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestParseDistribution(t *testing.T) {
+	td := []struct {
+		s    string
+		err  bool
+		expr Expression
+	}{
+		{"normal(500, 50)", false, distribution{"normal", []float64{500, 50}}},
+		{" uniform(1,2) ", false, distribution{"uniform", []float64{1, 2}}},
+		{"triangular(1, 2, 4)", false, distribution{"triangular", []float64{1, 2, 4}}},
+		{"lognormal(0, 0.5)", false, distribution{"lognormal", []float64{0, 0.5}}},
+		{"normal(500)", true, nil},
+		{"normal(500, -1)", true, nil},
+		{"uniform(2, 1)", true, nil},
+		{"triangular(1, 5, 4)", true, nil},
+		{"normal(a, 1)", true, nil},
+		{"(a+b)*3", false,
+			operation{
+				operator: "*",
+				left: operation{
+					operator: "+",
+					left: reference{"a"},
+					right: reference{"b"}},
+				right: constant{3}}},
+	}
+
+	for ix, d := range td {
+		seen, err := Parse(d.s)
+		if (err != nil) != d.err {
+			t.Errorf("test %d, expected error to be %v, saw %v", ix, d.err, err)
+			continue
+		}
+		if err == nil && !compareExpr(seen, d.expr) {
+			t.Errorf("test %d, saw %v, expected %v", ix, seen, d.expr)
+		}
+	}
+}
+
+func TestDistributionSamples(t *testing.T) {
+	td := []distribution{
+		{"normal", []float64{500, 50}},
+		{"lognormal", []float64{1, 0.25}},
+		{"uniform", []float64{10, 20}},
+		{"triangular", []float64{1, 2, 6}},
+	}
+	r := rand.New(rand.NewSource(1))
+	m := Model{}
+
+	for _, d := range td {
+		acc := 0.0
+		n := 20000
+		bounds := d.Range(m)
+		for i := 0; i < n; i++ {
+			v := d.Sample(r)
+			if d.kind != "normal" && d.kind != "lognormal" && (v < bounds.Min || v > bounds.Max) {
+				t.Errorf("%s, sample %f outside of %v", d.kind, v, bounds)
+			}
+			acc += v
+		}
+		mean := acc / float64(n)
+		expected := d.Value(m)
+		if math.Abs(mean-expected) > 0.02*expected {
+			t.Errorf("%s, sampled mean %f, expected about %f", d.kind, mean, expected)
+		}
+	}
+}
@@ -8,6 +8,8 @@ import (
 
 type Expression interface {
 	Value(Model) float64
+	Range(Model) Interval
+	String() string
 }
 
 type variable struct {
@@ -31,6 +33,12 @@ type reference struct {
 	name string
 }
 
+// A range of possible values, like 400..600
+type span struct {
+	min float64
+	max float64
+}
+
 
 func newVariable(name string, expr Expression) variable {
 	return variable{name, expr, []float64{0.0}, []bool{false}}
@@ -40,6 +48,19 @@ func (c constant) Value(m Model) float64 {
 	return c.value
 }
 
+func (c constant) Range(m Model) Interval {
+	return point(c.value)
+}
+
+// The point value of a span is its mid-point
+func (s span) Value(m Model) float64 {
+	return (s.min + s.max) / 2
+}
+
+func (s span) Range(m Model) Interval {
+	return Interval{s.min, s.max}
+}
+
 func (r reference) Value(m Model) float64 {
 	v, ok := m.Inputs[r.name]
 	if ok {
@@ -53,6 +74,19 @@ func (r reference) Value(m Model) float64 {
 	return -100000.0
 }
 
+func (r reference) Range(m Model) Interval {
+	v, ok := m.Inputs[r.name]
+	if ok {
+		return v.Range(m)
+	}
+	v2, ok2 := m.Variables[r.name]
+	if ok2 {
+		return v2.Range(m)
+	}
+	// Graphs refuse unknown references when created, see NewGraph
+	return point(-100000.0)
+}
+
 func (i Input) Value(m Model) float64 {
 	acc := 0.0
 	for _, iv := range i.values {
@@ -61,6 +95,14 @@ func (i Input) Value(m Model) float64 {
 	return acc
 }
 
+func (i Input) Range(m Model) Interval {
+	acc := point(0.0)
+	for _, iv := range i.values {
+		acc = acc.Add(iv.span)
+	}
+	return acc
+}
+
 func (v variable) Value(m Model) float64 {
 	if !v.cached[0] {
 		v.cache[0] = v.expr.Value(m)
@@ -69,6 +111,12 @@ func (v variable) Value(m Model) float64 {
 	return v.cache[0]
 }
 
+// Ranges of variables are not cached, as they are only needed when
+// printing results.
+func (v variable) Range(m Model) Interval {
+	return v.expr.Range(m)
+}
+
 func (v operation) Value(m Model) float64 {
 	lv := v.left.Value(m)
 	rv := v.right.Value(m)
@@ -82,3 +130,17 @@ func (v operation) Value(m Model) float64 {
 
 	return -100000.0
 }
+
+func (v operation) Range(m Model) Interval {
+	lv := v.left.Range(m)
+	rv := v.right.Range(m)
+
+	switch v.operator {
+	case "+": return lv.Add(rv)
+	case "-": return lv.Sub(rv)
+	case "*": return lv.Mul(rv)
+	case "/": return lv.Div(rv)
+	}
+
+	return point(-100000.0)
+}
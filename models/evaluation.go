@@ -0,0 +1,270 @@
+// Evaluation state, kept apart from the model definitions
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The top-level inputs, and overridden variables, for an evaluation.
+// Overrides are keyed by "model.variable".
+type Scenario struct {
+	Inputs    map[string]Expression
+	Overrides map[string]Expression
+}
+
+// The evaluated resources of a single model. RAM and CPU are per
+// replica, and the replica count is rounded up, and adjusted by the
+// policy of the model. RawReplicas is the count before the policy.
+type Result struct {
+	Model         string
+	Replicas      float64
+	RawReplicas   float64
+	RAM           float64
+	CPU           float64
+	ReplicasRange Interval
+	RAMRange      Interval
+	CPURange      Interval
+}
+
+// Returns the RAM needed by all replicas
+func (r Result) TotalRAM() float64 {
+	return r.RAM * r.Replicas
+}
+
+// Returns the CPU needed by all replicas
+func (r Result) TotalCPU() float64 {
+	return r.CPU * r.Replicas
+}
+
+// A single contribution to an input, and where it came from
+type Contribution struct {
+	Source string
+	Value  float64
+	Range  Interval
+}
+
+// The state of a single evaluation of a graph: the input
+// contributions, variable values and resource results. The model
+// definitions in the graph are shared, and never modified, so any
+// number of evaluations can be made from the same graph, and each
+// evaluation can be re-run with changed inputs and overrides without
+// re-loading the models.
+type Evaluation struct {
+	graph    *Graph
+	scenario Scenario
+	models   map[string]*Model
+	results  map[string]Result
+	changed  map[string]map[string]bool
+}
+
+// Returns a copy of a scenario, with all maps allocated
+func copyScenario(s Scenario) Scenario {
+	rv := Scenario{make(map[string]Expression), make(map[string]Expression)}
+	for name, v := range s.Inputs {
+		rv.Inputs[name] = v
+	}
+	for target, v := range s.Overrides {
+		rv.Overrides[target] = v
+	}
+	return rv
+}
+
+// Creates a new evaluation of the graph, it is not run until Run is
+// called.
+func (g *Graph) NewEvaluation(s Scenario) *Evaluation {
+	return &Evaluation{graph: g, scenario: copyScenario(s), changed: make(map[string]map[string]bool)}
+}
+
+// Creates and runs a new evaluation of the graph.
+func (g *Graph) Run(s Scenario) (*Evaluation, error) {
+	e := g.NewEvaluation(s)
+	return e, e.Run()
+}
+
+// Returns the graph being evaluated
+func (e *Evaluation) Graph() *Graph {
+	return e.graph
+}
+
+// Returns a copy of the current scenario
+func (e *Evaluation) Scenario() Scenario {
+	return copyScenario(e.scenario)
+}
+
+// Sets a top-level input, taking effect on the next Run or Recompute.
+func (e *Evaluation) SetInput(name string, v Expression) {
+	e.scenario.Inputs[name] = v
+	e.markChanged(e.graph.top, name)
+}
+
+// Overrides the expression of a variable, taking effect on the next
+// Run or Recompute. The target is "model.variable".
+func (e *Evaluation) Override(target string, v Expression) {
+	e.scenario.Overrides[target] = v
+	e.markOverride(target)
+}
+
+// Removes an override, taking effect on the next Run or Recompute.
+func (e *Evaluation) ClearOverride(target string) {
+	delete(e.scenario.Overrides, target)
+	e.markOverride(target)
+}
+
+// Splits an override target into a model and a variable, checking
+// that both exist.
+func (g *Graph) overrideTarget(target string) (string, string, error) {
+	parts := strings.SplitN(target, ".", 2)
+	m, ok := g.models[parts[0]]
+	if !ok || len(parts) != 2 {
+		return "", "", errors.New(fmt.Sprintf("Cannot override %s, no such model", target))
+	}
+	if _, ok := m.Variables[parts[1]]; !ok {
+		return "", "", errors.New(fmt.Sprintf("Cannot override %s, no such variable", target))
+	}
+	return parts[0], parts[1], nil
+}
+
+// Returns fresh copies of the model definitions, with the overrides
+// of the current scenario applied.
+func (e *Evaluation) prepare() (map[string]*Model, error) {
+	run := CloneModels(e.graph.models)
+	for target, v := range e.scenario.Overrides {
+		model, variable, err := e.graph.overrideTarget(target)
+		if err != nil {
+			return nil, err
+		}
+		run[model].Variables[variable] = newVariable(variable, v)
+	}
+	return run, nil
+}
+
+// Records the evaluated models and their results, computing every
+// result not already given, and forgets what changed before.
+func (e *Evaluation) finish(run map[string]*Model, results map[string]Result) {
+	for name, m := range run {
+		if _, ok := results[name]; !ok {
+			results[name] = newResult(m)
+		}
+	}
+	e.models = run
+	e.results = results
+	e.changed = make(map[string]map[string]bool)
+}
+
+// Evaluates the graph from scratch with the current scenario,
+// discarding the results of any earlier run.
+func (e *Evaluation) Run() error {
+	run, err := e.prepare()
+	if err != nil {
+		return err
+	}
+
+	sorted := []*Model{}
+	for _, name := range e.graph.order {
+		sorted = append(sorted, run[name])
+	}
+	propagate(run, sorted, e.graph.top, e.scenario.Inputs)
+
+	e.finish(run, make(map[string]Result))
+	return nil
+}
+
+// Computes the resources of an evaluated model.
+func newResult(m *Model) Result {
+	r := Result{Model: m.Name}
+	r.Replicas = replicaCount(m)
+	r.RawReplicas = rawReplicaCount(m)
+	r.ReplicasRange = replicaRange(m)
+	r.RAMRange = point(0)
+	r.CPURange = point(0)
+	if ram, ok := m.Resources["ram"]; ok {
+		r.RAM = ram.Value(*m)
+		r.RAMRange = ram.Range(*m)
+	}
+	if cpu, ok := m.Resources["cpu"]; ok {
+		r.CPU = cpu.Value(*m)
+		r.CPURange = cpu.Range(*m)
+	}
+	return r
+}
+
+// Returns the evaluated copies of all models, for printing and
+// explaining. They must not be modified.
+func (e *Evaluation) Models() map[string]*Model {
+	return e.models
+}
+
+// Returns the result for a single model
+func (e *Evaluation) Result(name string) (Result, bool) {
+	r, ok := e.results[name]
+	return r, ok
+}
+
+// Returns the results for all models, sorted by name
+func (e *Evaluation) Results() []Result {
+	rv := []Result{}
+	for _, name := range e.graph.Names() {
+		rv = append(rv, e.results[name])
+	}
+	return rv
+}
+
+// Returns the total RAM and CPU across all replicas of all models
+func (e *Evaluation) Totals() (float64, float64) {
+	ram := 0.0
+	cpu := 0.0
+	for _, r := range e.results {
+		ram += r.TotalRAM()
+		cpu += r.TotalCPU()
+	}
+	return ram, cpu
+}
+
+// Returns the contributions to an input of a model
+func (e *Evaluation) Contributions(model, input string) []Contribution {
+	rv := []Contribution{}
+	m, ok := e.models[model]
+	if !ok {
+		return rv
+	}
+	for _, iv := range m.Inputs[input].values {
+		rv = append(rv, Contribution{iv.source, iv.value, iv.span})
+	}
+	return rv
+}
+
+// Returns the value of a variable or input of a model
+func (e *Evaluation) Value(model, name string) (float64, bool) {
+	m, ok := e.models[model]
+	if !ok {
+		return 0, false
+	}
+	if input, ok := m.Inputs[name]; ok {
+		return input.Value(*m), true
+	}
+	if v, ok := m.Variables[name]; ok {
+		return v.Value(*m), true
+	}
+	return 0, false
+}
+
+// Evaluates an ad-hoc expression in the scope of an evaluated model,
+// returning its value and range. Fails if the expression refers to
+// anything that is not an input or variable of the model.
+func (e *Evaluation) Eval(model string, expr Expression) (float64, Interval, error) {
+	m, ok := e.models[model]
+	if !ok {
+		return 0, point(0), errors.New(fmt.Sprintf("No model named %s", model))
+	}
+	for _, ref := range references(expr) {
+		_, isInput := m.Inputs[ref]
+		_, isVariable := m.Variables[ref]
+		if !isInput && !isVariable {
+			return 0, point(0), errors.New(fmt.Sprintf("Model %s has no input or variable %s", model, ref))
+		}
+	}
+	return expr.Value(*m), expr.Range(*m), nil
+}
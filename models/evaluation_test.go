@@ -0,0 +1,133 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestEvaluationRerun(t *testing.T) {
+	g, _ := NewGraph(graphModels(), "front")
+	e, err := g.Run(Scenario{Inputs: map[string]Expression{"qps": constant{100}}})
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+
+	td := []struct {
+		qps      float64
+		override Expression
+		replicas float64
+	}{
+		{100, nil, 4},
+		{250, nil, 10},
+		{250, constant{1000}, 10},
+		{100, constant{1000}, 10},
+		{100, nil, 4},
+	}
+
+	for ix, d := range td {
+		e.SetInput("qps", constant{d.qps})
+		if d.override != nil {
+			e.Override("back.doubled", d.override)
+		} else {
+			e.ClearOverride("back.doubled")
+		}
+		if err := e.Run(); err != nil {
+			t.Fatalf("test %d, unexpected error, %s", ix, err)
+		}
+		r, ok := e.Result("back")
+		if !ok || r.Replicas != d.replicas {
+			t.Errorf("test %d, saw %f replicas, expected %f", ix, r.Replicas, d.replicas)
+		}
+		contributions := e.Contributions("back", "qps")
+		if len(contributions) != 1 || contributions[0].Source != "front" || contributions[0].Value != 2*d.qps {
+			t.Errorf("test %d, unexpected contributions %v", ix, contributions)
+		}
+	}
+
+	if v, ok := e.Value("back", "doubled"); !ok || v != 400 {
+		t.Errorf("Saw doubled %f, expected 400", v)
+	}
+	if _, ok := e.Value("back", "nope"); ok {
+		t.Errorf("Expected no value for back.nope")
+	}
+}
+
+func TestEvaluationResults(t *testing.T) {
+	models := graphModels()
+	models["back"].Resources["cpu"] = constant{2}
+	models["back"].Resources["ram"] = span{10, 20}
+	models["front"].Resources["cpu"] = constant{1}
+	g, _ := NewGraph(models, "front")
+
+	e, err := g.Run(Scenario{Inputs: map[string]Expression{"qps": constant{110}}})
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	results := e.Results()
+	if len(results) != 2 || results[0].Model != "back" || results[1].Model != "front" {
+		t.Fatalf("Unexpected results %v", results)
+	}
+	back := results[0]
+	if back.Replicas != 5 || back.TotalCPU() != 10 || back.TotalRAM() != 75 {
+		t.Errorf("Unexpected result for back, %v", back)
+	}
+	if back.RAMRange != (Interval{10, 20}) {
+		t.Errorf("Saw RAM range %v, expected 10..20", back.RAMRange)
+	}
+	ram, cpu := e.Totals()
+	if ram != 75 || cpu != 11 {
+		t.Errorf("Saw totals %f and %f, expected 75 and 11", ram, cpu)
+	}
+}
+
+func TestEvaluationOverrideErrors(t *testing.T) {
+	g, _ := NewGraph(graphModels(), "front")
+	for _, target := range []string{"nope.doubled", "back.nope", "back"} {
+		s := Scenario{Overrides: map[string]Expression{target: constant{1}}}
+		if _, err := g.Run(s); err == nil {
+			t.Errorf("Expected an error overriding %s", target)
+		}
+	}
+}
+
+func TestPropagateTwice(t *testing.T) {
+	models := graphModels()
+	inputs := map[string]Expression{"qps": constant{100}}
+	for i := 0; i < 2; i++ {
+		if err := Propagate(models, "front", inputs); err != nil {
+			t.Fatalf("Unexpected error, %s", err)
+		}
+	}
+	back := models["back"]
+	if seen := back.Inputs["qps"].Value(*back); seen != 200 {
+		t.Errorf("Saw qps %f after propagating twice, expected 200", seen)
+	}
+}
+
+func TestEvaluationEval(t *testing.T) {
+	g, _ := NewGraph(graphModels(), "front")
+	e, _ := g.Run(Scenario{Inputs: map[string]Expression{"qps": constant{100}}})
+
+	td := []struct {
+		model string
+		expr  string
+		err   bool
+		value float64
+	}{
+		{"back", "doubled / 2", false, 200},
+		{"back", "qps * 10..20", false, 3000},
+		{"front", "qps", false, 100},
+		{"front", "doubled", true, 0},
+		{"nope", "1", true, 0},
+	}
+
+	for ix, d := range td {
+		expr, _ := Parse(d.expr)
+		v, _, err := e.Eval(d.model, expr)
+		if (err != nil) != d.err {
+			t.Errorf("test %d, saw error %v, expected error %v", ix, err, d.err)
+		}
+		if err == nil && v != d.value {
+			t.Errorf("test %d, saw %f, expected %f", ix, v, d.value)
+		}
+	}
+}
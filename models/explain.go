@@ -0,0 +1,161 @@
+// Explaining how values were derived
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Returns the names referenced by an expression, each name once, in
+// the order they first appear.
+func references(e Expression) []string {
+	rv := []string{}
+	seen := make(map[string]bool)
+	var walk func(Expression)
+	walk = func(e Expression) {
+		switch x := e.(type) {
+		case reference:
+			if !seen[x.name] {
+				seen[x.name] = true
+				rv = append(rv, x.name)
+			}
+		case variable:
+			walk(x.expr)
+		case operation:
+			walk(x.left)
+			walk(x.right)
+		}
+	}
+	walk(e)
+	return rv
+}
+
+// State for explaining a single value
+type explainer struct {
+	w      io.Writer
+	models map[string]*Model
+	seen   map[string]bool
+}
+
+// Prints the symbolic and substituted forms of an expression, then
+// explains everything it refers to.
+func (x *explainer) expression(m *Model, e Expression, indent string) {
+	if _, ok := e.(constant); !ok {
+		symbolic := e.String()
+		substituted := render(e, func(name string) string {
+			return formatNumber(reference{name}.Value(*m))
+		})
+		fmt.Fprintf(x.w, "%s= %s\n", indent, symbolic)
+		if substituted != symbolic {
+			fmt.Fprintf(x.w, "%s= %s\n", indent, substituted)
+		}
+	}
+	for _, name := range references(e) {
+		x.name(m, name, indent)
+	}
+}
+
+// Explains an input or a variable of a model.
+func (x *explainer) name(m *Model, name, indent string) {
+	key := m.Name + "." + name
+	input, isInput := m.Inputs[name]
+	v, isVariable := m.Variables[name]
+	switch {
+	case x.seen[key]:
+		fmt.Fprintf(x.w, "%s%s = %s # see above\n", indent, name, formatNumber(reference{name}.Value(*m)))
+	case isInput:
+		x.seen[key] = true
+		fmt.Fprintf(x.w, "%s%s = %s # input%s\n", indent, name, formatNumber(input.Value(*m)), rangeComment(input.Range(*m)))
+		for _, iv := range input.values {
+			x.source(m, name, iv, indent+"  ")
+		}
+	case isVariable:
+		x.seen[key] = true
+		fmt.Fprintf(x.w, "%s%s = %s%s\n", indent, name, formatNumber(v.Value(*m)), commentFor(v.Range(*m)))
+		x.expression(m, v.expr, indent+"  ")
+	default:
+		fmt.Fprintf(x.w, "%s%s # unknown in model %s\n", indent, name, m.Name)
+	}
+}
+
+// Explains where a contribution to an input came from, following the
+// outputs of the model it came from.
+func (x *explainer) source(m *Model, name string, iv inputValue, indent string) {
+	fmt.Fprintf(x.w, "%s%s from %s\n", indent, formatNumber(iv.value), iv.source)
+	src, ok := x.models[iv.source]
+	if !ok {
+		return
+	}
+	for _, o := range src.Outputs {
+		if o.backend == m.Name && o.input == name {
+			fmt.Fprintf(x.w, "%s  %s.outputs[%s.%s]\n", indent, src.Name, m.Name, name)
+			x.expression(src, o.value, indent+"    ")
+		}
+	}
+}
+
+// Returns a stand-alone comment for a range, if it is wide.
+func commentFor(r Interval) string {
+	if r.Wide() {
+		return fmt.Sprintf(" # range %s", r)
+	}
+	return ""
+}
+
+// Explains how a value in an evaluated set of models was derived.
+// The target is either "model.name", where the name is a resource,
+// variable or input, or just "model" to explain every resource of the
+// model. Every referenced value is explained in turn, including which
+// upstream model, and which of its outputs, fed each input.
+func Explain(w io.Writer, models map[string]*Model, target string) error {
+	parts := strings.SplitN(target, ".", 2)
+	m, ok := models[parts[0]]
+	if !ok {
+		return errors.New(fmt.Sprintf("No model named %s", parts[0]))
+	}
+	x := explainer{w, models, make(map[string]bool)}
+
+	names := []string{}
+	if len(parts) == 2 {
+		names = append(names, parts[1])
+	} else {
+		for _, resource := range []string{"ram", "cpu", "replicas"} {
+			if _, ok := m.Resources[resource]; ok || resource == "replicas" {
+				names = append(names, resource)
+			}
+		}
+	}
+
+	for _, name := range names {
+		e, isResource := m.Resources[name]
+		if name == "replicas" {
+			e, isResource = replicaExpr(m), true
+		}
+		if !isResource {
+			if _, ok := m.Inputs[name]; !ok {
+				if _, ok := m.Variables[name]; !ok {
+					return errors.New(fmt.Sprintf("No resource, variable or input named %s in model %s", name, m.Name))
+				}
+			}
+			x.name(m, name, "")
+			continue
+		}
+
+		value := e.Value(*m)
+		r := e.Range(*m)
+		if name == "replicas" && !m.Policy.IsZero() {
+			fmt.Fprintf(x.w, "%s.%s = %s # %s rounded up from %s, then policy %s%s\n", m.Name, name, formatNumber(replicaCount(m)), formatNumber(math.Ceil(value)), formatNumber(value), m.Policy, rangeComment(replicaRange(m)))
+		} else if name == "replicas" {
+			fmt.Fprintf(x.w, "%s.%s = %s # rounded up from %s%s\n", m.Name, name, formatNumber(math.Ceil(value)), formatNumber(value), rangeComment(r.Ceil()))
+		} else {
+			fmt.Fprintf(x.w, "%s.%s = %s%s\n", m.Name, name, formatNumber(value), commentFor(r))
+		}
+		x.expression(m, e, "  ")
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	a := reference{"a"}
+	b := reference{"b"}
+	c := reference{"c"}
+	td := []struct {
+		e        Expression
+		expected string
+	}{
+		{constant{1.5}, "1.5"},
+		{span{400, 600}, "400..600"},
+		{distribution{"normal", []float64{500, 50}}, "normal(500, 50)"},
+		{operation{"+", a, operation{"*", b, c}}, "a + b * c"},
+		{operation{"*", operation{"+", a, b}, c}, "(a + b) * c"},
+		{operation{"-", operation{"-", a, b}, c}, "a - b - c"},
+		{operation{"-", a, operation{"-", b, c}}, "a - (b - c)"},
+		{operation{"/", a, operation{"*", b, c}}, "a / (b * c)"},
+		{operation{"+", a, operation{"+", b, c}}, "a + b + c"},
+	}
+
+	for ix, d := range td {
+		seen := render(d.e, func(name string) string { return name })
+		if seen != d.expected {
+			t.Errorf("test %d, saw %q, expected %q", ix, seen, d.expected)
+		}
+	}
+}
+
+func TestReferences(t *testing.T) {
+	e := operation{"*", operation{"+", reference{"a"}, reference{"b"}}, operation{"/", reference{"a"}, constant{2}}}
+	seen := references(e)
+	if strings.Join(seen, ",") != "a,b" {
+		t.Errorf("Saw %v, expected [a b]", seen)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	front := New("front")
+	front.NewInput("qps")
+	front.NewOutput("back", "qps", operation{"*", reference{"qps"}, constant{2}})
+	back := New("back")
+	back.NewInput("qps")
+	back.Variables["per_replica"] = newVariable("per_replica", constant{100})
+	back.Resources["replicas"] = operation{"/", reference{"qps"}, reference{"per_replica"}}
+	models := map[string]*Model{"front": front, "back": back}
+	if err := Propagate(models, "front", map[string]Expression{"qps": constant{250}}); err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := Explain(&buf, models, "back.replicas"); err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	seen := buf.String()
+	for _, expected := range []string{
+		"back.replicas = 5 # rounded up from 5\n",
+		"  = qps / per_replica\n",
+		"  = 500 / 100\n",
+		"  qps = 500 # input\n",
+		"    500 from front\n",
+		"      front.outputs[back.qps]\n",
+		"        = qps * 2\n",
+		"        = 250 * 2\n",
+		"          250 from external\n",
+		"  per_replica = 100\n",
+	} {
+		if !strings.Contains(seen, expected) {
+			t.Errorf("Explanation lacks %q:\n%s", expected, seen)
+		}
+	}
+
+	for _, target := range []string{"nope.replicas", "back.nope"} {
+		if err := Explain(&buf, models, target); err == nil {
+			t.Errorf("Expected an error explaining %s", target)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Forecasting resources over a planning horizon, as inputs grow
+
+package models
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// How a top-level input grows from month to month, either by adding
+// a fixed amount (Linear), or by a percentage of the month before
+// (Compound).
+type Growth struct {
+	Linear   float64 `yaml:"linear"`
+	Compound float64 `yaml:"compound"`
+}
+
+// Limits on the replicas, and the RAM and CPU across all replicas, of
+// a model. Limits left at 0 are not checked.
+type Threshold struct {
+	Replicas float64 `yaml:"replicas"`
+	RAM      float64 `yaml:"ram"`
+	CPU      float64 `yaml:"cpu"`
+}
+
+// What to forecast. Inputs with a growth start from their value on
+// the command line, inputs in the CSV file take the value for each
+// month from it, and any other input stays the same. Values holds the
+// monthly values from the CSV file, by input.
+type ForecastSpec struct {
+	Months     int                  `yaml:"months"`
+	Growth     map[string]Growth    `yaml:"growth"`
+	CSV        string               `yaml:"csv"`
+	Thresholds map[string]Threshold `yaml:"thresholds"`
+	Values     map[string][]float64 `yaml:"-"`
+}
+
+// The results of every model in one month, sorted by name, with the
+// RAM and CPU across all of them.
+type ForecastMonth struct {
+	Month   int
+	Results []Result
+	RAM     float64
+	CPU     float64
+}
+
+// The first month a resource of a model is over its threshold
+type Crossing struct {
+	Model     string
+	Resource  string
+	Month     int
+	Value     float64
+	Threshold float64
+}
+
+// A forecast, month by month from month 0, and every threshold that
+// is crossed, in the order they are crossed.
+type Forecast struct {
+	Months    []ForecastMonth
+	Crossings []Crossing
+}
+
+// Parses a forecast spec. The CSV file it names is not read, see
+// LoadForecastSpec. The horizon defaults to 24 months.
+func ParseForecastSpec(data []byte) (ForecastSpec, error) {
+	rv := ForecastSpec{}
+	if err := yaml.UnmarshalStrict(data, &rv); err != nil {
+		return rv, errors.New(fmt.Sprintf("Bad forecast, %s", err))
+	}
+	if rv.Months == 0 {
+		rv.Months = 24
+	}
+	if rv.Months < 0 {
+		return rv, errors.New(fmt.Sprintf("Bad forecast, %d months", rv.Months))
+	}
+	for name, g := range rv.Growth {
+		if g.Linear != 0 && g.Compound != 0 {
+			return rv, errors.New(fmt.Sprintf("Input %s cannot grow both linearly and compounded", name))
+		}
+	}
+	return rv, nil
+}
+
+// Parses monthly input values from CSV. The first row names the
+// inputs, and every following row holds the values for one month,
+// starting at month 0. A column named "month" is ignored.
+func ParseMonthlyValues(r io.Reader) (map[string][]float64, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Bad monthly values, %s", err))
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("Bad monthly values, no header")
+	}
+	rv := make(map[string][]float64)
+	header := rows[0]
+	for ix, row := range rows[1:] {
+		for col, name := range header {
+			name = strings.TrimSpace(name)
+			if name == "month" {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(row[col]), 64)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Bad value for %s in month %d, %s", name, ix, err))
+			}
+			rv[name] = append(rv[name], v)
+		}
+	}
+	return rv, nil
+}
+
+// Loads a forecast spec and, if it names one, its CSV file. The CSV
+// file is relative to the spec.
+func LoadForecastSpec(filename string) (ForecastSpec, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return ForecastSpec{}, err
+	}
+	spec, err := ParseForecastSpec(data)
+	if err != nil || spec.CSV == "" {
+		return spec, err
+	}
+	csvFile := spec.CSV
+	if !filepath.IsAbs(csvFile) {
+		csvFile = filepath.Join(filepath.Dir(filename), csvFile)
+	}
+	f, err := os.Open(csvFile)
+	if err != nil {
+		return spec, err
+	}
+	defer f.Close()
+	spec.Values, err = ParseMonthlyValues(f)
+	return spec, err
+}
+
+// Returns the top-level inputs for a month
+func (s ForecastSpec) inputs(start map[string]Expression, month int) (map[string]Expression, error) {
+	rv := make(map[string]Expression)
+	for name, e := range start {
+		rv[name] = e
+	}
+	for name, g := range s.Growth {
+		e, ok := start[name]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Input %s grows, but has no starting value", name))
+		}
+		switch {
+		case g.Linear != 0:
+			rv[name] = operation{"+", e, constant{g.Linear * float64(month)}}
+		case g.Compound != 0:
+			rv[name] = operation{"*", e, constant{math.Pow(1+g.Compound/100, float64(month))}}
+		}
+	}
+	for name, values := range s.Values {
+		if month >= len(values) {
+			return nil, errors.New(fmt.Sprintf("No value for %s in month %d", name, month))
+		}
+		rv[name] = constant{values[month]}
+	}
+	return rv, nil
+}
+
+// Evaluates the graph for every month from 0 to the horizon, and
+// finds the first month every threshold is crossed. Fails if the
+// horizon is negative, or a threshold is for a model not in the graph.
+func (s ForecastSpec) Run(g *Graph, start map[string]Expression) (Forecast, error) {
+	rv := Forecast{}
+	if s.Months < 0 {
+		return rv, errors.New(fmt.Sprintf("Bad forecast, %d months", s.Months))
+	}
+	for name, _ := range s.Thresholds {
+		if _, ok := g.Model(name); !ok {
+			return rv, errors.New(fmt.Sprintf("Threshold for unknown model %s", name))
+		}
+	}
+	crossed := make(map[string]bool)
+	for month := 0; month <= s.Months; month++ {
+		inputs, err := s.inputs(start, month)
+		if err != nil {
+			return rv, err
+		}
+		e, err := g.Run(Scenario{Inputs: inputs})
+		if err != nil {
+			return rv, errors.New(fmt.Sprintf("Failed to evaluate month %d, %s", month, err))
+		}
+		fm := ForecastMonth{Month: month, Results: e.Results()}
+		fm.RAM, fm.CPU = e.Totals()
+		rv.Months = append(rv.Months, fm)
+
+		for _, r := range fm.Results {
+			t, ok := s.Thresholds[r.Model]
+			if !ok {
+				continue
+			}
+			checks := []struct {
+				resource string
+				v        float64
+				limit    float64
+			}{
+				{"replicas", r.Replicas, t.Replicas},
+				{"ram", r.TotalRAM(), t.RAM},
+				{"cpu", r.TotalCPU(), t.CPU},
+			}
+			for _, c := range checks {
+				key := r.Model + "." + c.resource
+				if c.limit > 0 && c.v > c.limit && !crossed[key] {
+					crossed[key] = true
+					rv.Crossings = append(rv.Crossings, Crossing{r.Model, c.resource, month, c.v, c.limit})
+				}
+			}
+		}
+	}
+	return rv, nil
+}
+
+// Prints a forecast as a table per model, of the replicas, and the
+// RAM and CPU across all replicas, every month. The month a threshold
+// is crossed is marked in the table, and listed at the end.
+func PrintForecast(w io.Writer, f Forecast) {
+	marks := make(map[string]string)
+	for _, c := range f.Crossings {
+		key := fmt.Sprintf("%s.%d", c.Model, c.Month)
+		if marks[key] != "" {
+			marks[key] += ", "
+		}
+		marks[key] += fmt.Sprintf("%s over %s", c.Resource, formatNumber(c.Threshold))
+	}
+
+	if len(f.Months) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "forecast: # %d months\n", len(f.Months)-1)
+	for ix, r := range f.Months[0].Results {
+		fmt.Fprintf(w, "- name: %s\n", r.Model)
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "  month\treplicas\tram\tcpu\n")
+		for _, m := range f.Months {
+			r := m.Results[ix]
+			fmt.Fprintf(tw, "  %d\t%.0f\t%.0f\t%.2f", m.Month, r.Replicas, r.TotalRAM(), r.TotalCPU())
+			if mark, ok := marks[fmt.Sprintf("%s.%d", r.Model, m.Month)]; ok {
+				fmt.Fprintf(tw, "\t# %s", mark)
+			}
+			fmt.Fprintf(tw, "\n")
+		}
+		tw.Flush()
+	}
+
+	fmt.Fprintf(w, "\ntotals:\n")
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "  month\tram\tcpu\n")
+	for _, m := range f.Months {
+		fmt.Fprintf(tw, "  %d\t%.0f\t%.2f\n", m.Month, m.RAM, m.CPU)
+	}
+	tw.Flush()
+
+	if len(f.Crossings) > 0 {
+		fmt.Fprintf(w, "\ncrossings:\n")
+		for _, c := range f.Crossings {
+			fmt.Fprintf(w, "- %s %s is %.6g in month %d, over %s\n", c.Model, c.Resource, c.Value, c.Month, formatNumber(c.Threshold))
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseForecastSpec(t *testing.T) {
+	spec, err := ParseForecastSpec([]byte("growth:\n  qps:\n    compound: 5\nthresholds:\n  back:\n    replicas: 30\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if spec.Months != 24 || spec.Growth["qps"].Compound != 5 || spec.Thresholds["back"].Replicas != 30 {
+		t.Errorf("Unexpected spec %v", spec)
+	}
+	for _, data := range []string{
+		"months: -1\n",
+		"growth:\n  qps:\n    linear: 5\n    compound: 5\n",
+		"growth:\n  qps:\n    exponential: 5\n",
+	} {
+		if _, err := ParseForecastSpec([]byte(data)); err == nil {
+			t.Errorf("Expected an error for %q", data)
+		}
+	}
+}
+
+func TestParseMonthlyValues(t *testing.T) {
+	values, err := ParseMonthlyValues(strings.NewReader("month,qps,users\n0,100,10\n1, 150 ,20\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if len(values) != 2 || len(values["qps"]) != 2 || values["qps"][1] != 150 || values["users"][0] != 10 {
+		t.Errorf("Unexpected values %v", values)
+	}
+	if _, err := ParseMonthlyValues(strings.NewReader("qps\nlots\n")); err == nil {
+		t.Errorf("Expected an error for a bad value")
+	}
+}
+
+func TestForecastInputs(t *testing.T) {
+	start := map[string]Expression{"a": constant{100}, "b": constant{100}, "c": constant{100}}
+	spec := ForecastSpec{
+		Growth: map[string]Growth{"a": {Linear: 10}, "b": {Compound: 10}},
+		Values: map[string][]float64{"d": {1, 2, 3}},
+	}
+	inputs, err := spec.inputs(start, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	expected := map[string]float64{"a": 120, "b": 121, "c": 100, "d": 3}
+	for name, v := range expected {
+		if seen := inputs[name].Value(Model{}); math.Abs(seen-v) > 1e-9 {
+			t.Errorf("Saw %s %f, expected %f", name, seen, v)
+		}
+	}
+	if _, err := spec.inputs(start, 3); err == nil {
+		t.Errorf("Expected an error past the end of the monthly values")
+	}
+	spec.Growth["e"] = Growth{Linear: 1}
+	if _, err := spec.inputs(start, 0); err == nil {
+		t.Errorf("Expected an error for growth without a starting value")
+	}
+}
+
+func TestForecastRun(t *testing.T) {
+	g, err := NewGraph(graphModels(), "front")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	spec := ForecastSpec{
+		Months:     12,
+		Growth:     map[string]Growth{"qps": {Linear: 100}},
+		Thresholds: map[string]Threshold{"back": {Replicas: 30}, "front": {Replicas: 5}},
+	}
+	f, err := spec.Run(g, map[string]Expression{"qps": constant{500}})
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if len(f.Months) != 13 {
+		t.Fatalf("Saw %d months, expected 13", len(f.Months))
+	}
+	// back needs qps*4/100 replicas
+	if back := f.Months[12].Results[0]; back.Model != "back" || back.Replicas != 68 {
+		t.Errorf("Saw %v in month 12, expected 68 replicas of back", back)
+	}
+	if len(f.Crossings) != 1 || f.Crossings[0] != (Crossing{"back", "replicas", 3, 32, 30}) {
+		t.Errorf("Unexpected crossings %v", f.Crossings)
+	}
+
+	bad := spec
+	bad.Months = -1
+	if _, err := bad.Run(g, map[string]Expression{"qps": constant{500}}); err == nil {
+		t.Errorf("Expected an error for a negative horizon")
+	}
+	bad = spec
+	bad.Thresholds = map[string]Threshold{"bakc": {Replicas: 30}}
+	if _, err := bad.Run(g, map[string]Expression{"qps": constant{500}}); err == nil {
+		t.Errorf("Expected an error for a threshold of an unknown model")
+	}
+
+	var buf bytes.Buffer
+	PrintForecast(&buf, f)
+	for _, s := range []string{"forecast: # 12 months\n- name: back\n", "# replicas over 30\n", "- back replicas is 32 in month 3, over 30\n"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("Expected %q in the forecast, saw\n%s", s, buf.String())
+		}
+	}
+}
+
+func TestLoadForecastSpec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forecast")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "spec.yaml"), []byte("months: 1\ncsv: qps.csv\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "qps.csv"), []byte("qps\n100\n200\n"), 0644)
+
+	spec, err := LoadForecastSpec(filepath.Join(dir, "spec.yaml"))
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if len(spec.Values["qps"]) != 2 || spec.Values["qps"][1] != 200 {
+		t.Errorf("Unexpected values %v", spec.Values)
+	}
+}
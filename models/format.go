@@ -0,0 +1,86 @@
+// Canonical formatting of model files
+
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Returns an expression in its canonical form.
+func formatExpression(model, what, s string) (string, error) {
+	e, err := Parse(s)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("model %s, %s: %s", model, what, err))
+	}
+	return e.String(), nil
+}
+
+// Returns the first line with a comment, or 0 if there is none. A #
+// starts a comment at the start of a line, or after a space, unless
+// it is in a quoted string.
+func commentLine(data []byte) int {
+	for ix, line := range strings.Split(string(data), "\n") {
+		var quote rune
+		prev := ' '
+		for _, c := range line {
+			switch {
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+			case c == '\'' || c == '"':
+				quote = c
+			case c == '#' && (prev == ' ' || prev == '\t'):
+				return ix + 1
+			}
+			prev = c
+		}
+	}
+	return 0
+}
+
+// Formats the content of a model file. Sections are written in a
+// fixed order, variables and resources are sorted by name, and every
+// expression is rewritten in its canonical form. Models, inputs and
+// outputs keep their order. As formatting would lose them, files with
+// comments or unknown keys are refused.
+func Format(data []byte) ([]byte, error) {
+	if line := commentLine(data); line > 0 {
+		return nil, errors.New(fmt.Sprintf("Comment on line %d, which formatting would remove", line))
+	}
+	ext := []ExternalModel{}
+	err := yaml.UnmarshalStrict(data, &ext)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Bad models, %s", err))
+	}
+
+	for ix, m := range ext {
+		for oIx, o := range m.Outputs {
+			what := fmt.Sprintf("output %s.%s", o.Backend, o.Input)
+			if ext[ix].Outputs[oIx].Expression, err = formatExpression(m.Name, what, o.Expression); err != nil {
+				return nil, err
+			}
+		}
+		for name, v := range m.Variables {
+			if m.Variables[name], err = formatExpression(m.Name, "variable "+name, v); err != nil {
+				return nil, err
+			}
+		}
+		for name, r := range m.Resources {
+			if m.Resources[name], err = formatExpression(m.Name, "resource "+name, r); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteExternalModels(&buf, ext); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	input := `- name: test
+  resources:
+    replicas: qps/qps_per_replica
+    cpu: 0.75
+  outputs:
+  - backend: other
+    input: qps
+    expression: (qps*2)
+  variables:
+    qps_per_replica: 500
+    lightweight: qps*0.99
+  inputs:
+  - qps
+- name: other
+  inputs: [qps]
+`
+	expected := `- name: test
+  inputs:
+   - qps
+  variables:
+   lightweight: qps * 0.99
+   qps_per_replica: 500
+  outputs:
+   - backend: other
+     input: qps
+     expression: qps * 2
+  resources:
+   cpu: 0.75
+   replicas: qps / qps_per_replica
+
+- name: other
+  inputs:
+   - qps
+`
+	seen, err := Format([]byte(input))
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if string(seen) != expected {
+		t.Errorf("Saw:\n%s\nexpected:\n%s", seen, expected)
+	}
+
+	again, _ := Format(seen)
+	if string(again) != string(seen) {
+		t.Errorf("Formatting is not stable, saw:\n%s", again)
+	}
+
+	bad := []string{
+		"- name: bad\n  variables:\n    a: normal(1)\n",
+		"- name: bad\n  resorces:\n    cpu: 1\n",
+		"# The frontends\n- name: bad\n",
+		"- name: bad\n  resources:\n    cpu: 1 # per replica\n",
+	}
+	for ix, d := range bad {
+		if _, err := Format([]byte(d)); err == nil {
+			t.Errorf("test %d, expected an error", ix)
+		}
+	}
+
+	if _, err := Format([]byte("- name: \"not # a comment\"\n")); err != nil {
+		t.Errorf("Unexpected error for a # in a string, %s", err)
+	}
+}
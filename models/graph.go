@@ -0,0 +1,192 @@
+// Graphs of models, evaluated as many times as needed
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A set of model definitions, and the top-level model that receives
+// the external inputs. The definitions are never evaluated directly,
+// each evaluation works on fresh copies of them.
+type Graph struct {
+	top    string
+	models map[string]*Model
+	order  []string
+	layers [][]string
+}
+
+// Creates a graph from a set of models, which are copied. Fails if
+// the top-level model is missing, if an output feeds a model or input
+// that does not exist, if an output or resource is a distribution,
+// which only variables and inputs are sampled from, if the models
+// depend on each other in a circle, or if the graph does not compile,
+// see Compile.
+func NewGraph(models map[string]*Model, top string) (*Graph, error) {
+	if _, ok := models[top]; !ok {
+		return nil, errors.New(fmt.Sprintf("Top-level model %s not found.", top))
+	}
+	for _, m := range models {
+		if err := m.Policy.Validate(); err != nil {
+			return nil, errors.New(fmt.Sprintf("Model %s has a bad policy, %s", m.Name, err))
+		}
+		for _, o := range m.Outputs {
+			dst, ok := models[o.backend]
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("Model %s has an output to unknown model %s", m.Name, o.backend))
+			}
+			if _, ok := o.value.(distribution); ok {
+				return nil, errors.New(fmt.Sprintf("Model %s has a distribution for its output to %s.%s, only variables and inputs can be distributions", m.Name, o.backend, o.input))
+			}
+			if _, ok := dst.Inputs[o.input]; !ok {
+				return nil, errors.New(fmt.Sprintf("Model %s has an output to unknown input %s.%s", m.Name, o.backend, o.input))
+			}
+		}
+		for name, r := range m.Resources {
+			if _, ok := r.(distribution); ok {
+				return nil, errors.New(fmt.Sprintf("Model %s has a distribution for resource %s, only variables and inputs can be distributions", m.Name, name))
+			}
+		}
+	}
+
+	g := Graph{top: top, models: CloneModels(models)}
+	layers, err := topoLayers(modelsToDepMap(g.models))
+	if err != nil {
+		return nil, err
+	}
+	g.layers = layers
+	for _, layer := range layers {
+		g.order = append(g.order, layer...)
+	}
+	if _, err := g.Compile(); err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
+
+// Creates a graph from serialized models, see NewGraph.
+func GraphFromExternal(ext []ExternalModel, top string) (*Graph, error) {
+	models := make(map[string]*Model)
+	for _, e := range ext {
+		if _, ok := models[e.Name]; ok {
+			return nil, errors.New(fmt.Sprintf("Model %s defined more than once", e.Name))
+		}
+		m, err := ModelFromExternal(e)
+		if err != nil {
+			return nil, err
+		}
+		models[e.Name] = m
+	}
+	return NewGraph(models, top)
+}
+
+// Returns the name of the top-level model for a model file or
+// directory, its base name without any YAML extension.
+func TopName(filename string) string {
+	base := filepath.Base(filename)
+	switch {
+	case strings.HasSuffix(base, ".yaml"):
+		base = base[:len(base)-5]
+	case strings.HasSuffix(base, ".yml"):
+		base = base[:len(base)-4]
+	}
+	return base
+}
+
+// Returns the model files to load for a file or directory, for a
+// directory every YAML file in it, sorted.
+func modelFiles(filename string) ([]string, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{filename}, nil
+	}
+	entries, err := ioutil.ReadDir(filename)
+	if err != nil {
+		return nil, err
+	}
+	rv := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && (strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml")) {
+			rv = append(rv, filepath.Join(filename, name))
+		}
+	}
+	sort.Strings(rv)
+	return rv, nil
+}
+
+// Loads a graph from a model file, or from all model files in a
+// directory. The top-level model is named after the file or
+// directory, see TopName.
+func LoadGraph(filename string) (*Graph, error) {
+	files, err := modelFiles(filename)
+	if err != nil {
+		return nil, err
+	}
+	ext := []ExternalModel{}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		loaded, err := LoadExternalModels(f)
+		f.Close()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error loading %s, %s", file, err))
+		}
+		ext = append(ext, loaded...)
+	}
+	return GraphFromExternal(ext, TopName(filename))
+}
+
+// Returns the name of the top-level model
+func (g *Graph) Top() string {
+	return g.top
+}
+
+// Returns the definition of a model. It must not be modified.
+func (g *Graph) Model(name string) (*Model, bool) {
+	m, ok := g.models[name]
+	return m, ok
+}
+
+// Returns the names of all models, sorted.
+func (g *Graph) Names() []string {
+	return sortedModelNames(g.models)
+}
+
+// Returns the names of all models, in evaluation order.
+func (g *Graph) Order() []string {
+	return append([]string{}, g.order...)
+}
+
+// Returns the names of all models in layers, where the models in each
+// layer only depend on models in earlier layers.
+func (g *Graph) Layers() [][]string {
+	rv := [][]string{}
+	for _, layer := range g.layers {
+		rv = append(rv, append([]string{}, layer...))
+	}
+	return rv
+}
+
+// Evaluates the graph with the given top-level inputs, returning
+// evaluated copies of all models. The graph itself is not modified,
+// so can be evaluated any number of times. See Run for more control.
+func (g *Graph) Evaluate(inputs map[string]Expression) (map[string]*Model, error) {
+	e, err := g.Run(Scenario{Inputs: inputs})
+	if err != nil {
+		return nil, err
+	}
+	return e.Models(), nil
+}
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func graphModels() map[string]*Model {
+	front := New("front")
+	front.NewInput("qps")
+	front.NewOutput("back", "qps", operation{"*", reference{"qps"}, constant{2}})
+	back := New("back")
+	back.NewInput("qps")
+	back.Variables["doubled"] = newVariable("doubled", operation{"*", reference{"qps"}, constant{2}})
+	back.Resources["replicas"] = operation{"/", reference{"doubled"}, constant{100}}
+
+	return map[string]*Model{"front": front, "back": back}
+}
+
+func TestNewGraph(t *testing.T) {
+	g, err := NewGraph(graphModels(), "front")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	order := g.Order()
+	if len(order) != 2 || order[0] != "front" || order[1] != "back" {
+		t.Errorf("Saw order %v, expected [front back]", order)
+	}
+	names := g.Names()
+	if len(names) != 2 || names[0] != "back" || names[1] != "front" {
+		t.Errorf("Saw names %v, expected [back front]", names)
+	}
+
+	if _, err := NewGraph(graphModels(), "nope"); err == nil {
+		t.Errorf("Expected an error for a missing top-level model")
+	}
+
+	unknownModel := graphModels()
+	unknownModel["front"].NewOutput("nope", "qps", constant{1})
+	if _, err := NewGraph(unknownModel, "front"); err == nil {
+		t.Errorf("Expected an error for an output to an unknown model")
+	}
+
+	unknownInput := graphModels()
+	unknownInput["front"].NewOutput("back", "nope", constant{1})
+	if _, err := NewGraph(unknownInput, "front"); err == nil {
+		t.Errorf("Expected an error for an output to an unknown input")
+	}
+
+	// Only variables and inputs are sampled, so a distribution anywhere
+	// else would always be its mean
+	sampledResource := graphModels()
+	sampledResource["back"].Resources["cpu"] = distribution{"normal", []float64{4, 1}}
+	if _, err := NewGraph(sampledResource, "front"); err == nil {
+		t.Errorf("Expected an error for a resource that is a distribution")
+	}
+
+	sampledOutput := graphModels()
+	sampledOutput["front"].Outputs[0].value = distribution{"uniform", []float64{1, 2}}
+	if _, err := NewGraph(sampledOutput, "front"); err == nil {
+		t.Errorf("Expected an error for an output that is a distribution")
+	}
+
+	circular := graphModels()
+	circular["back"].NewOutput("front", "qps", constant{1})
+	if _, err := NewGraph(circular, "front"); err == nil {
+		t.Errorf("Expected an error for circular models")
+	}
+}
+
+func TestEvaluateRepeatedly(t *testing.T) {
+	g, _ := NewGraph(graphModels(), "front")
+
+	td := []struct {
+		qps      float64
+		replicas float64
+	}{
+		{100, 4},
+		{250, 10},
+		{100, 4},
+	}
+
+	for ix, d := range td {
+		run, err := g.Evaluate(map[string]Expression{"qps": constant{d.qps}})
+		if err != nil {
+			t.Fatalf("test %d, unexpected error, %s", ix, err)
+		}
+		back := run["back"]
+		seen := back.Resources["replicas"].Value(*back)
+		if seen != d.replicas {
+			t.Errorf("test %d, saw %f replicas, expected %f", ix, seen, d.replicas)
+		}
+	}
+
+	def, _ := g.Model("back")
+	if len(def.Inputs["qps"].values) != 0 || def.Variables["doubled"].cached[0] {
+		t.Errorf("Evaluation modified the model definition")
+	}
+}
+
+func TestLoadGraph(t *testing.T) {
+	dir, err := ioutil.TempDir("", "planning")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	defer os.RemoveAll(dir)
+	top := filepath.Join(dir, "front")
+	os.Mkdir(top, 0755)
+	files := map[string]string{
+		"front.yaml": "- name: front\n  inputs:\n   - qps\n  outputs:\n   - backend: back\n     input: qps\n     expression: qps * 2\n",
+		"back.yml":   "- name: back\n  inputs:\n   - qps\n  resources:\n   replicas: qps / 100\n",
+		"notes.txt":  "not a model",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(top, name), []byte(data), 0644); err != nil {
+			t.Fatalf("Unexpected error, %s", err)
+		}
+	}
+
+	g, err := LoadGraph(top + "/")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if g.Top() != "front" || len(g.Names()) != 2 {
+		t.Errorf("Saw top %s and models %v", g.Top(), g.Names())
+	}
+
+	if _, err := LoadGraph(filepath.Join(top, "front.yaml")); err == nil {
+		t.Errorf("Expected an error loading a file with an output to a missing model")
+	}
+	if _, err := LoadGraph(filepath.Join(dir, "nope")); err == nil {
+		t.Errorf("Expected an error loading a missing directory")
+	}
+	if name := TopName("models/uploads.yml"); name != "uploads" {
+		t.Errorf("Saw top-level name %s, expected uploads", name)
+	}
+}
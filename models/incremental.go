@@ -0,0 +1,239 @@
+// Incremental recomputation of evaluations
+
+package models
+
+import (
+	"sort"
+	"strings"
+)
+
+// The names referenced by each variable, output and resource of a
+// model. Outputs are in the same order as in the model.
+type dependencies struct {
+	variables map[string][]string
+	outputs   [][]string
+	resources map[string][]string
+}
+
+func newDependencies(m *Model) dependencies {
+	d := dependencies{make(map[string][]string), [][]string{}, make(map[string][]string)}
+	for name, v := range m.Variables {
+		d.variables[name] = references(v.expr)
+	}
+	for _, o := range m.Outputs {
+		d.outputs = append(d.outputs, references(o.value))
+	}
+	for name, r := range m.Resources {
+		d.resources[name] = references(r)
+	}
+	return d
+}
+
+// Returns true if any of the references is in the set of names
+func refersTo(refs []string, names map[string]bool) bool {
+	for _, ref := range refs {
+		if names[ref] {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the names in a model affected by changes to the given
+// names, including the changed names themselves.
+func (d dependencies) affected(changed map[string]bool) map[string]bool {
+	rv := make(map[string]bool)
+	for name, _ := range changed {
+		rv[name] = true
+	}
+	for more := true; more; {
+		more = false
+		for name, refs := range d.variables {
+			if !rv[name] && refersTo(refs, rv) {
+				rv[name] = true
+				more = true
+			}
+		}
+	}
+	return rv
+}
+
+// Records that an input or variable of a model has changed since the
+// last run.
+func (e *Evaluation) markChanged(model, name string) {
+	if e.changed[model] == nil {
+		e.changed[model] = make(map[string]bool)
+	}
+	e.changed[model][name] = true
+}
+
+func (e *Evaluation) markOverride(target string) {
+	parts := strings.SplitN(target, ".", 2)
+	if len(parts) == 2 {
+		e.markChanged(parts[0], parts[1])
+	}
+}
+
+// Returns a fresh copy of a single model definition, with the
+// overrides of the current scenario applied.
+func (e *Evaluation) prepareModel(name string) *Model {
+	m := e.graph.models[name].Clone()
+	for target, v := range e.scenario.Overrides {
+		parts := strings.SplitN(target, ".", 2)
+		if parts[0] == name {
+			m.Variables[parts[1]] = newVariable(parts[1], v)
+		}
+	}
+	return m
+}
+
+// Replaces the contributions from recomputed sources in a list of
+// input values, keeping them where the old contributions were.
+// Contributions from sources that did not contribute before are added
+// last.
+func spliceInputValues(old []inputValue, fresh map[string][]inputValue) []inputValue {
+	rv := []inputValue{}
+	done := make(map[string]bool)
+	for _, iv := range old {
+		values, ok := fresh[iv.source]
+		if !ok {
+			rv = append(rv, iv)
+			continue
+		}
+		if !done[iv.source] {
+			rv = append(rv, values...)
+			done[iv.source] = true
+		}
+	}
+	sources := []string{}
+	for source, _ := range fresh {
+		if !done[source] {
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		rv = append(rv, fresh[source]...)
+	}
+	return rv
+}
+
+// Brings the evaluation up to date with the inputs and overrides
+// changed since the last run, recomputing only the models affected by
+// the changes. A model is affected if one of its own variables was
+// overridden, or if an output feeding one of its inputs refers,
+// directly or through variables, to something that changed. Returns
+// the names of the recomputed models, in evaluation order. If the
+// evaluation has never been run, it is run in full.
+func (e *Evaluation) Recompute() ([]string, error) {
+	if e.models == nil {
+		if err := e.Run(); err != nil {
+			return nil, err
+		}
+		return e.graph.Order(), nil
+	}
+	for target, _ := range e.scenario.Overrides {
+		if _, _, err := e.graph.overrideTarget(target); err != nil {
+			return nil, err
+		}
+	}
+
+	// Contributions to recompute, by model, input and source
+	pending := make(map[string]map[string]map[string][]inputValue)
+	expect := func(model, input, source string) {
+		if pending[model] == nil {
+			pending[model] = make(map[string]map[string][]inputValue)
+		}
+		if pending[model][input] == nil {
+			pending[model][input] = make(map[string][]inputValue)
+		}
+		if _, ok := pending[model][input][source]; !ok {
+			pending[model][input][source] = []inputValue{}
+		}
+	}
+
+	top := e.models[e.graph.top]
+	for _, name := range sortedNames(e.changed[e.graph.top]) {
+		if _, ok := top.Inputs[name]; !ok {
+			continue
+		}
+		expect(e.graph.top, name, "external")
+		if v, ok := e.scenario.Inputs[name]; ok {
+			pending[e.graph.top][name]["external"] = []inputValue{{source: "external", value: v.Value(*top), span: v.Range(*top)}}
+		}
+	}
+
+	run := make(map[string]*Model)
+	for name, m := range e.models {
+		run[name] = m
+	}
+	results := make(map[string]Result)
+	for name, r := range e.results {
+		results[name] = r
+	}
+
+	recomputed := []string{}
+	for _, name := range e.graph.order {
+		changed := make(map[string]bool)
+		for n, _ := range e.changed[name] {
+			changed[n] = true
+		}
+		for input, _ := range pending[name] {
+			changed[input] = true
+		}
+		if len(changed) == 0 {
+			continue
+		}
+
+		m := e.prepareModel(name)
+		old := e.models[name]
+		for input, _ := range m.Inputs {
+			values := old.Inputs[input].values
+			if fresh, ok := pending[name][input]; ok {
+				values = spliceInputValues(values, fresh)
+			}
+			for _, iv := range values {
+				m.addInputValue(input, iv)
+			}
+		}
+
+		deps := newDependencies(m)
+		affected := deps.affected(changed)
+		for ix, o := range m.Outputs {
+			if refersTo(deps.outputs[ix], affected) {
+				expect(o.backend, o.input, name)
+			}
+		}
+		for _, o := range m.Outputs {
+			if fresh, ok := pending[o.backend][o.input]; ok {
+				if _, ok := fresh[name]; ok {
+					fresh[name] = append(fresh[name], inputValue{source: name, value: o.value.Value(*m), span: o.value.Range(*m)})
+				}
+			}
+		}
+
+		run[name] = m
+		for _, refs := range deps.resources {
+			if refersTo(refs, affected) {
+				results[name] = newResult(m)
+				break
+			}
+		}
+		recomputed = append(recomputed, name)
+	}
+
+	e.models = run
+	e.results = results
+	e.changed = make(map[string]map[string]bool)
+	return recomputed, nil
+}
+
+// Returns the names in a set, sorted
+func sortedNames(names map[string]bool) []string {
+	rv := []string{}
+	for name, _ := range names {
+		rv = append(rv, name)
+	}
+	sort.Strings(rv)
+	return rv
+}
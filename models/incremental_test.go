@@ -0,0 +1,137 @@
+package models
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func incrementalModels() map[string]*Model {
+	models := graphModels()
+	front := models["front"]
+	front.NewInput("uploads")
+	front.NewOutput("store", "bytes", operation{"*", reference{"uploads"}, constant{10}})
+	store := New("store")
+	store.NewInput("bytes")
+	store.Resources["ram"] = reference{"bytes"}
+	models["store"] = store
+	return models
+}
+
+// Checks that an incrementally recomputed evaluation matches one run
+// from scratch.
+func compareEvaluations(t *testing.T, ix int, seen, expected *Evaluation) {
+	for _, r := range expected.Results() {
+		s, _ := seen.Result(r.Model)
+		if s != r {
+			t.Errorf("test %d, saw %v, expected %v", ix, s, r)
+		}
+		for input, _ := range expected.Models()[r.Model].Inputs {
+			sc := seen.Contributions(r.Model, input)
+			ec := expected.Contributions(r.Model, input)
+			if len(sc) != len(ec) {
+				t.Errorf("test %d, %s.%s, saw %v, expected %v", ix, r.Model, input, sc, ec)
+				continue
+			}
+			for cIx, c := range ec {
+				if sc[cIx] != c {
+					t.Errorf("test %d, %s.%s, saw %v, expected %v", ix, r.Model, input, sc, ec)
+				}
+			}
+		}
+	}
+}
+
+func TestRecompute(t *testing.T) {
+	g, err := NewGraph(incrementalModels(), "front")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	e := g.NewEvaluation(Scenario{Inputs: map[string]Expression{"qps": constant{100}, "uploads": constant{5}}})
+	if seen, err := e.Recompute(); err != nil || len(seen) != 3 {
+		t.Fatalf("Saw %v (%v), expected a full run", seen, err)
+	}
+
+	td := []struct {
+		input    string
+		target   string
+		value    Expression
+		expected []string
+	}{
+		{"uploads", "", constant{7}, []string{"front", "store"}},
+		{"qps", "", constant{250}, []string{"front", "back"}},
+		{"", "back.doubled", constant{1000}, []string{"back"}},
+		{"", "back.doubled", nil, []string{"back"}},
+		{"", "", nil, []string{}},
+	}
+
+	for ix, d := range td {
+		switch {
+		case d.input != "":
+			e.SetInput(d.input, d.value)
+		case d.value != nil:
+			e.Override(d.target, d.value)
+		case d.target != "":
+			e.ClearOverride(d.target)
+		}
+		seen, err := e.Recompute()
+		if err != nil {
+			t.Fatalf("test %d, unexpected error, %s", ix, err)
+		}
+		if len(seen) != len(d.expected) {
+			t.Errorf("test %d, recomputed %v, expected %v", ix, seen, d.expected)
+		} else {
+			for mIx, name := range d.expected {
+				if seen[mIx] != name {
+					t.Errorf("test %d, recomputed %v, expected %v", ix, seen, d.expected)
+				}
+			}
+		}
+		full, _ := g.Run(e.Scenario())
+		compareEvaluations(t, ix, e, full)
+	}
+
+	e.Override("back.nope", constant{1})
+	if _, err := e.Recompute(); err == nil {
+		t.Errorf("Expected an error overriding an unknown variable")
+	}
+}
+
+func TestRecomputeMatchesRun(t *testing.T) {
+	g, err := NewGraph(wideModels(4, 6), "top")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	e, _ := g.Run(Scenario{Inputs: map[string]Expression{"qps": constant{1000}}})
+	names := g.Order()[1:]
+	r := rand.New(rand.NewSource(1))
+	for ix := 0; ix < 20; ix++ {
+		switch r.Intn(3) {
+		case 0:
+			e.SetInput("qps", constant{float64(r.Intn(10000))})
+		case 1:
+			e.Override(names[r.Intn(len(names))]+".scaled", constant{float64(r.Intn(1000))})
+		case 2:
+			for target, _ := range e.Scenario().Overrides {
+				e.ClearOverride(target)
+				break
+			}
+		}
+		if _, err := e.Recompute(); err != nil {
+			t.Fatalf("test %d, unexpected error, %s", ix, err)
+		}
+		full, _ := g.Run(e.Scenario())
+		compareEvaluations(t, ix, e, full)
+	}
+}
+
+func BenchmarkRecompute(b *testing.B) {
+	g, _ := NewGraph(wideModels(10, 20), "top")
+	e, _ := g.Run(Scenario{Inputs: map[string]Expression{"qps": constant{10000}}})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.Override("m8_3.scaled", constant{float64(i)})
+		if _, err := e.Recompute(); err != nil {
+			b.Fatalf("Unexpected error, %s", err)
+		}
+	}
+}
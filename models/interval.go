@@ -0,0 +1,69 @@
+// Interval arithmetic, for evaluating models with uncertain values
+
+package models
+
+import (
+	"fmt"
+	"math"
+)
+
+// A closed range of values, from Min to Max inclusive.
+type Interval struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// Returns the interval containing only v.
+func point(v float64) Interval {
+	return Interval{v, v}
+}
+
+// Returns the mid-point of an interval.
+func (i Interval) Mid() float64 {
+	return (i.Min + i.Max) / 2
+}
+
+// Returns true if the interval contains more than a single value.
+func (i Interval) Wide() bool {
+	return i.Min != i.Max
+}
+
+func (i Interval) String() string {
+	return fmt.Sprintf("%f..%f", i.Min, i.Max)
+}
+
+func (i Interval) Add(o Interval) Interval {
+	return Interval{i.Min + o.Min, i.Max + o.Max}
+}
+
+func (i Interval) Sub(o Interval) Interval {
+	return Interval{i.Min - o.Max, i.Max - o.Min}
+}
+
+func (i Interval) Mul(o Interval) Interval {
+	a := i.Min * o.Min
+	b := i.Min * o.Max
+	c := i.Max * o.Min
+	d := i.Max * o.Max
+	return Interval{
+		math.Min(math.Min(a, b), math.Min(c, d)),
+		math.Max(math.Max(a, b), math.Max(c, d)),
+	}
+}
+
+// Divides one interval by another. If the divisor spans 0, there are
+// no bounds on the result.
+func (i Interval) Div(o Interval) Interval {
+	if o.Min <= 0 && o.Max >= 0 {
+		if !o.Wide() && !i.Wide() {
+			return point(i.Min / o.Min)
+		}
+		return Interval{math.Inf(-1), math.Inf(1)}
+	}
+	return i.Mul(Interval{1 / o.Max, 1 / o.Min})
+}
+
+// Rounds both ends of the interval up.
+func (i Interval) Ceil() Interval {
+	return Interval{math.Ceil(i.Min), math.Ceil(i.Max)}
+}
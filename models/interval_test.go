@@ -0,0 +1,51 @@
+package models
+
+import (
+	"math"
+	"testing"
+)
+
+func TestIntervalArithmetic(t *testing.T) {
+	a := Interval{1, 2}
+	b := Interval{-3, 4}
+	td := []struct {
+		op       string
+		seen     Interval
+		expected Interval
+	}{
+		{"+", a.Add(b), Interval{-2, 6}},
+		{"-", a.Sub(b), Interval{-3, 5}},
+		{"*", a.Mul(b), Interval{-6, 8}},
+		{"/", b.Div(a), Interval{-3, 4}},
+		{"/", a.Div(Interval{2, 4}), Interval{0.25, 1}},
+		{"/", a.Div(b), Interval{math.Inf(-1), math.Inf(1)}},
+		{"ceil", Interval{0.2, 3.5}.Ceil(), Interval{1, 4}},
+	}
+
+	for ix, d := range td {
+		if d.seen != d.expected {
+			t.Errorf("test %d (%s), saw %v, expected %v", ix, d.op, d.seen, d.expected)
+		}
+	}
+}
+
+func TestRangeEvaluation(t *testing.T) {
+	model := Model{}
+	model.Inputs = map[string]Input{}
+	model.NewInput("qps")
+	model.SetInputRange("qps", "test", Interval{1000, 2000})
+	model.SetInput("qps", "test", 500)
+	model.Variables = map[string]variable{
+		"per_replica": newVariable("per_replica", span{400, 600}),
+	}
+
+	replicas := operation{"/", reference{"qps"}, reference{"per_replica"}}
+	seen := replicas.Range(model)
+	expected := Interval{1500.0 / 600.0, 2500.0 / 400.0}
+	if seen != expected {
+		t.Errorf("Saw %v, expected %v", seen, expected)
+	}
+	if v := replicas.Value(model); v != 4.0 {
+		t.Errorf("Saw point value %f, expected 4", v)
+	}
+}
@@ -0,0 +1,265 @@
+// Comparing planned capacity against what is currently deployed
+
+package models
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// What is currently deployed of a model. RAM (in bytes) and CPU are
+// per replica, like the resources of a model, and are nil if unknown.
+type Deployed struct {
+	Name     string   `yaml:"name"`
+	Replicas float64  `yaml:"replicas"`
+	RAM      *float64 `yaml:"ram"`
+	CPU      *float64 `yaml:"cpu"`
+}
+
+// The planned and deployed amount of a resource, across all replicas.
+// Gap is positive for a surplus, and negative for a shortfall, and
+// Utilization is the share of what is deployed that the plan uses.
+type Gap struct {
+	Resource    string
+	Planned     float64
+	Deployed    float64
+	Gap         float64
+	Utilization float64
+}
+
+// The gaps of a model. A model can be planned but not deployed, or
+// deployed but not part of the plan.
+type ModelGap struct {
+	Model    string
+	Planned  bool
+	Deployed bool
+	Gaps     []Gap
+}
+
+// The gaps of every model, sorted by name, the RAM and CPU gaps
+// across all models, and the models with a shortfall.
+type GapReport struct {
+	Models    []ModelGap
+	Totals    []Gap
+	Shortfall []string
+}
+
+// Parses an inventory, a YAML list of deployed models.
+func ParseInventory(data []byte) ([]Deployed, error) {
+	rv := []Deployed{}
+	if err := yaml.UnmarshalStrict(data, &rv); err != nil {
+		return nil, errors.New(fmt.Sprintf("Bad inventory, %s", err))
+	}
+	return rv, checkInventory(rv)
+}
+
+// Parses an inventory in CSV. The first row names the columns, which
+// are name, replicas and, optionally, ram and cpu. Empty ram and cpu
+// values are unknown.
+func ParseInventoryCSV(r io.Reader) ([]Deployed, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Bad inventory, %s", err))
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("Bad inventory, no header")
+	}
+	columns := make(map[string]int)
+	for ix, name := range rows[0] {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "name", "replicas", "ram", "cpu":
+			columns[name] = ix
+		default:
+			return nil, errors.New(fmt.Sprintf("Bad inventory, unknown column %s", name))
+		}
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("Bad inventory, no name column")
+	}
+	if _, ok := columns["replicas"]; !ok {
+		return nil, errors.New("Bad inventory, no replicas column")
+	}
+
+	rv := []Deployed{}
+	for _, row := range rows[1:] {
+		d := Deployed{Name: strings.TrimSpace(row[columns["name"]])}
+		for _, column := range []string{"replicas", "ram", "cpu"} {
+			ix, ok := columns[column]
+			if !ok {
+				continue
+			}
+			s := strings.TrimSpace(row[ix])
+			if s == "" && column != "replicas" {
+				continue
+			}
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Bad %s for %s, %s", column, d.Name, err))
+			}
+			switch column {
+			case "replicas":
+				d.Replicas = v
+			case "ram":
+				d.RAM = &v
+			case "cpu":
+				d.CPU = &v
+			}
+		}
+		rv = append(rv, d)
+	}
+	return rv, checkInventory(rv)
+}
+
+// Checks that every model in an inventory is named, and only once
+func checkInventory(inv []Deployed) error {
+	seen := make(map[string]bool)
+	for _, d := range inv {
+		if d.Name == "" {
+			return errors.New("Bad inventory, model without a name")
+		}
+		if seen[d.Name] {
+			return errors.New(fmt.Sprintf("Bad inventory, %s listed more than once", d.Name))
+		}
+		seen[d.Name] = true
+	}
+	return nil
+}
+
+// Loads an inventory, in CSV if the file name ends in .csv, and
+// otherwise in YAML.
+func LoadInventory(filename string) ([]Deployed, error) {
+	if strings.HasSuffix(strings.ToLower(filename), ".csv") {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ParseInventoryCSV(f)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseInventory(data)
+}
+
+func newGap(resource string, planned, deployed float64) Gap {
+	g := Gap{Resource: resource, Planned: planned, Deployed: deployed, Gap: deployed - planned}
+	if deployed > 0 {
+		g.Utilization = planned / deployed
+	}
+	return g
+}
+
+// Compares the evaluated models against an inventory. Resources not
+// known to be deployed are only compared for models that are not
+// deployed at all.
+func CompareInventory(models map[string]*Model, inv []Deployed) GapReport {
+	deployed := make(map[string]Deployed)
+	for _, d := range inv {
+		deployed[d.Name] = d
+	}
+	names := sortedModelNames(models)
+	for name, _ := range deployed {
+		if _, ok := models[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	rv := GapReport{}
+	var plannedRAM, plannedCPU, deployedRAM, deployedCPU float64
+	for _, name := range names {
+		mg := ModelGap{Model: name}
+		var replicas, ram, cpu float64
+		if m, ok := models[name]; ok {
+			mg.Planned = true
+			replicas, ram, cpu = replicaCount(m), allRAM(m), allCPU(m)
+		}
+		d, ok := deployed[name]
+		mg.Deployed = ok
+		mg.Gaps = append(mg.Gaps, newGap("replicas", replicas, d.Replicas))
+		if !ok || d.RAM != nil {
+			dRAM := 0.0
+			if ok {
+				dRAM = *d.RAM * d.Replicas
+			}
+			mg.Gaps = append(mg.Gaps, newGap("ram", ram, dRAM))
+			plannedRAM += ram
+			deployedRAM += dRAM
+		}
+		if !ok || d.CPU != nil {
+			dCPU := 0.0
+			if ok {
+				dCPU = *d.CPU * d.Replicas
+			}
+			mg.Gaps = append(mg.Gaps, newGap("cpu", cpu, dCPU))
+			plannedCPU += cpu
+			deployedCPU += dCPU
+		}
+		for _, g := range mg.Gaps {
+			if g.Gap < 0 {
+				rv.Shortfall = append(rv.Shortfall, name)
+				break
+			}
+		}
+		rv.Models = append(rv.Models, mg)
+	}
+	rv.Totals = []Gap{newGap("ram", plannedRAM, deployedRAM), newGap("cpu", plannedCPU, deployedCPU)}
+	return rv
+}
+
+// Prints a gap, with replicas as whole numbers
+func printGap(w io.Writer, indent string, g Gap) {
+	f := "%f"
+	if g.Resource == "replicas" {
+		f = "%.0f"
+	}
+	gap := fmt.Sprintf("surplus "+f, g.Gap)
+	if g.Gap < 0 {
+		gap = fmt.Sprintf("short "+f, -g.Gap)
+	}
+	utilization := "nothing deployed"
+	if g.Deployed > 0 {
+		utilization = fmt.Sprintf("%.0f%% utilization", 100*g.Utilization)
+	}
+	fmt.Fprintf(w, "%s%s: "+f+" # "+f+" planned, %s, %s\n", indent, g.Resource, g.Deployed, g.Planned, gap, utilization)
+}
+
+// Prints the deployed and planned resources of every model, the gap
+// between them, and a verdict.
+func PrintGapReport(w io.Writer, r GapReport) {
+	fmt.Fprintf(w, "\ninventory: # deployed against planned\n")
+	for _, m := range r.Models {
+		switch {
+		case !m.Planned:
+			fmt.Fprintf(w, "- name: %s # not in the plan\n", m.Model)
+		case !m.Deployed:
+			fmt.Fprintf(w, "- name: %s # not deployed\n", m.Model)
+		default:
+			fmt.Fprintf(w, "- name: %s\n", m.Model)
+		}
+		for _, g := range m.Gaps {
+			printGap(w, "  ", g)
+		}
+	}
+	fmt.Fprintf(w, "\ntotals:\n")
+	for _, g := range r.Totals {
+		printGap(w, " ", g)
+	}
+	if len(r.Shortfall) > 0 {
+		fmt.Fprintf(w, "\nverdict: shortfall # %s need more capacity\n", strings.Join(r.Shortfall, ", "))
+	} else {
+		fmt.Fprintf(w, "\nverdict: sufficient # the current deployment covers the plan\n")
+	}
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseInventory(t *testing.T) {
+	inv, err := ParseInventory([]byte("- name: web\n  replicas: 10\n  cpu: 2\n- name: batch\n  replicas: 4\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if len(inv) != 2 || inv[0].Name != "web" || inv[0].CPU == nil || *inv[0].CPU != 2 || inv[0].RAM != nil {
+		t.Errorf("Unexpected inventory %v", inv)
+	}
+	for _, data := range []string{
+		"- name: web\n  replicas: 10\n- name: web\n  replicas: 1\n",
+		"- replicas: 10\n",
+		"- name: web\n  disk: 10\n",
+	} {
+		if _, err := ParseInventory([]byte(data)); err == nil {
+			t.Errorf("Expected an error for %q", data)
+		}
+	}
+}
+
+func TestParseInventoryCSV(t *testing.T) {
+	inv, err := ParseInventoryCSV(strings.NewReader("name,replicas,ram,cpu\nweb,10,,2\nbatch, 4 ,1024,\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if len(inv) != 2 || inv[0].RAM != nil || *inv[0].CPU != 2 || inv[1].Replicas != 4 || *inv[1].RAM != 1024 || inv[1].CPU != nil {
+		t.Errorf("Unexpected inventory %v", inv)
+	}
+	for _, data := range []string{
+		"name,ram\nweb,10\n",
+		"name,replicas,disk\nweb,1,1\n",
+		"name,replicas\nweb,\n",
+		"name,replicas\nweb,1\nweb,2\n",
+	} {
+		if _, err := ParseInventoryCSV(strings.NewReader(data)); err == nil {
+			t.Errorf("Expected an error for %q", data)
+		}
+	}
+}
+
+func TestCompareInventory(t *testing.T) {
+	g, err := NewGraph(profileModels(), "front")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	// web needs 10 replicas of 2 cores, batch 10 of 4 cores
+	usage, err := g.Evaluate(map[string]Expression{"qps": constant{1000}, "jobs": constant{100}})
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	two, four := 2.0, 4.0
+	inv := []Deployed{
+		{Name: "web", Replicas: 16, CPU: &two},
+		{Name: "batch", Replicas: 8, CPU: &four},
+		{Name: "legacy", Replicas: 3},
+	}
+	r := CompareInventory(usage, inv)
+	if len(r.Models) != 4 {
+		t.Fatalf("Saw %d models, expected 4", len(r.Models))
+	}
+	batch := r.Models[0]
+	if batch.Model != "batch" || len(batch.Gaps) != 2 || batch.Gaps[1] != (Gap{"cpu", 40, 32, -8, 1.25}) {
+		t.Errorf("Unexpected batch gaps %v", batch)
+	}
+	web := r.Models[3]
+	if web.Gaps[0] != (Gap{"replicas", 10, 16, 6, 0.625}) {
+		t.Errorf("Unexpected web gaps %v", web)
+	}
+	if front := r.Models[1]; front.Model != "front" || front.Deployed || len(front.Gaps) != 3 {
+		t.Errorf("Expected front planned but not deployed, saw %v", front)
+	}
+	if legacy := r.Models[2]; legacy.Model != "legacy" || legacy.Planned || legacy.Gaps[0].Gap != 3 {
+		t.Errorf("Expected legacy deployed but not planned, saw %v", legacy)
+	}
+	if cpu := r.Totals[1]; cpu.Planned != 60 || cpu.Deployed != 64 {
+		t.Errorf("Unexpected cpu totals %v", cpu)
+	}
+	if len(r.Shortfall) != 2 || r.Shortfall[0] != "batch" || r.Shortfall[1] != "front" {
+		t.Errorf("Saw shortfall in %v, expected batch and front", r.Shortfall)
+	}
+
+	var buf bytes.Buffer
+	PrintGapReport(&buf, r)
+	for _, s := range []string{
+		"  cpu: 32.000000 # 40.000000 planned, short 8.000000, 125% utilization\n",
+		"- name: legacy # not in the plan\n  replicas: 3 # 0 planned, surplus 3, 0% utilization\n",
+		"verdict: shortfall # batch, front need more capacity\n",
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("Expected %q in the report, saw\n%s", s, buf.String())
+		}
+	}
+}
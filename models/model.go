@@ -7,7 +7,8 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math"
+	"sort"
+	"strings"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -19,20 +20,22 @@ type Model struct {
 	Outputs   []Output
 	Variables map[string]variable
 	Resources map[string]Expression
+	Policy    Policy
 }
 
 type ExternalOutput struct {
-	Backend    string
-	Input      string
-	Expression string
+	Backend    string `json:"backend"`
+	Input      string `json:"input"`
+	Expression string `json:"expression"`
 }
 // Serialization representation of a model
 type ExternalModel struct {
-	Name    string
-	Inputs  []string
-	Outputs []ExternalOutput
-	Variables map[string]string
-	Resources map[string]string
+	Name    string `json:"name"`
+	Inputs  []string `json:"inputs"`
+	Variables map[string]string `json:"variables,omitempty"`
+	Outputs []ExternalOutput `json:"outputs"`
+	Resources map[string]string `json:"resources,omitempty"`
+	Policy  *Policy `yaml:"policy,omitempty" json:"policy,omitempty"`
 }
 
 // Model inputs
@@ -45,6 +48,7 @@ type Input struct {
 type inputValue struct {
 	source string
 	value float64
+	span Interval
 }
 
 // Output representation
@@ -54,14 +58,8 @@ type Output struct {
 	value   Expression
 }
 
-var models map[string]*Model
-
 // Creates a new model with the given name
 func New(name string) *Model {
-	if spec, ok := models[name]; ok {
-		return spec
-	}
-		
 	m := Model{Name: name}
 	m.Inputs = make(map[string]Input)
 	m.Outputs = []Output{}
@@ -71,6 +69,46 @@ func New(name string) *Model {
 	return &m
 }
 
+// Returns a copy of the model with no input values and no cached
+// variable values. Expressions are shared, as they are never modified
+// once parsed.
+func (m *Model) Clone() *Model {
+	c := New(m.Name)
+	for name, _ := range m.Inputs {
+		c.NewInput(name)
+	}
+	c.Outputs = append(c.Outputs, m.Outputs...)
+	for name, v := range m.Variables {
+		c.Variables[name] = newVariable(name, v.expr)
+	}
+	for name, r := range m.Resources {
+		c.Resources[name] = r
+	}
+	c.Policy = m.Policy
+
+	return c
+}
+
+// Removes all input values and cached variable values from a model,
+// so that it can be propagated again.
+func (m *Model) Reset() {
+	for name, _ := range m.Inputs {
+		m.NewInput(name)
+	}
+	for name, v := range m.Variables {
+		m.Variables[name] = newVariable(name, v.expr)
+	}
+}
+
+// Clones every model in a model map, see Model.Clone
+func CloneModels(models map[string]*Model) map[string]*Model {
+	rv := make(map[string]*Model)
+	for name, m := range models {
+		rv[name] = m.Clone()
+	}
+	return rv
+}
+
 // Creates a new input on a model
 func (m *Model) NewInput(name string) {
 	i := Input{name: name}
@@ -86,9 +124,24 @@ func (m *Model) NewOutput(destination, sink string, value Expression) {
 
 // Sets a specific input on a model to a specific value
 func (m *Model) SetInput(iName, from string, v float64) {
+	m.addInputValue(iName, inputValue{source: from, value: v, span: point(v)})
+}
+
+// Sets a specific input on a model to a range of values, the point
+// value being the mid-point of the range.
+func (m *Model) SetInputRange(iName, from string, r Interval) {
+	m.addInputValue(iName, inputValue{source: from, value: r.Mid(), span: r})
+}
+
+// Sets a specific input on a model from the value of an expression,
+// evaluated in the source model.
+func (m *Model) setInputFrom(iName, from string, e Expression, src Model) {
+	m.addInputValue(iName, inputValue{source: from, value: e.Value(src), span: e.Range(src)})
+}
+
+func (m *Model) addInputValue(iName string, iv inputValue) {
 	input, ok := m.Inputs[iName]
 	if ok {
-		iv := inputValue{source: from, value: v}
 		input.values = append(input.values, iv)
 		m.Inputs[iName] = input
 	}
@@ -100,7 +153,7 @@ func (m *Model) PropagateOutputs(models map[string]*Model) {
 	for _, o := range m.Outputs {
 		dst, ok := models[o.backend]
 		if ok {
-			dst.SetInput(o.input, m.Name, o.value.Value(*m))
+			dst.setInputFrom(o.input, m.Name, o.value, *m)
 		} else {
 			fmt.Printf("PropoagateOuputs: <model %s> No model named %s (%v)\n", m.Name, o.backend, models)
 		}
@@ -120,25 +173,42 @@ func noDeps(reqs []string, used map[string]bool) bool {
 // Topologically sorts a dependency tree. Expects a map keyed by model
 // name, with a list of model names that a specific module depends on.
 func topoSort(deps map[string][]string) ([]string, error) {
-	used := make(map[string]bool)
+	layers, err := topoLayers(deps)
+	if err != nil {
+		return nil, err
+	}
 	rv := []string{}
+	for _, layer := range layers {
+		rv = append(rv, layer...)
+	}
 
-	for len(rv) < len(deps) {
+	return rv, nil
+}
+
+// Splits a dependency tree into layers, where every name in a layer
+// only depends on names in earlier layers, so all names in a layer can
+// be evaluated at the same time. Each layer is sorted.
+func topoLayers(deps map[string][]string) ([][]string, error) {
+	used := make(map[string]bool)
+	rv := [][]string{}
+	count := 0
+
+	for count < len(deps) {
 		possibles := []string{}
 		for candidate, reqs := range deps {
-			if noDeps(reqs, used) {
+			if !used[candidate] && noDeps(reqs, used) {
 				possibles = append(possibles, candidate)
 			}
 		}
 		if len(possibles) == 0 {
 			return nil, errors.New("No available candidates in topoSort")
 		}
+		sort.Strings(possibles)
 		for _, possible := range possibles {
-			if !used[possible] {
-				rv = append(rv, possible)
-				used[possible] = true
-			}
+			used[possible] = true
 		}
+		rv = append(rv, possibles)
+		count += len(possibles)
 	}
 
 	return rv, nil
@@ -174,7 +244,9 @@ func ModelOrder(models map[string]*Model) ([]*Model, error) {
 	return rv, nil
 }
 
-func ModelFromExternal(e ExternalModel) *Model {
+// Creates a model from its serialized form. Fails if any expression
+// does not parse.
+func ModelFromExternal(e ExternalModel) (*Model, error) {
 	m := New(e.Name)
 	for _, input := range e.Inputs {
 		m.NewInput(input)
@@ -186,27 +258,34 @@ func ModelFromExternal(e ExternalModel) *Model {
 
 		if backend != "" && input != "" && expression != "" {
 			expr, err := Parse(expression)
-			if err == nil {
-				m.NewOutput(backend, input, expr)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Model %s, bad output to %s.%s, %s", e.Name, backend, input, err))
 			}
+			m.NewOutput(backend, input, expr)
 		}
 	}
 
-	for v, e := range e.Variables {
-		expr, err := Parse(e)
-		if err == nil {
-			m.Variables[v] = newVariable(v, expr)
+	for v, value := range e.Variables {
+		expr, err := Parse(value)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Model %s, bad variable %s, %s", e.Name, v, err))
 		}
+		m.Variables[v] = newVariable(v, expr)
 	}
 
 	for resource, expr := range e.Resources {
 		parsed, err := Parse(expr)
-		if err == nil {
-			m.Resources[resource] = parsed
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Model %s, bad resource %s, %s", e.Name, resource, err))
 		}
+		m.Resources[resource] = parsed
 	}
 
-	return m
+	if e.Policy != nil {
+		m.Policy = *e.Policy
+	}
+
+	return m, nil
 }
 
 func BuildExternal(data []byte) (ExternalModel, error) {
@@ -225,65 +304,101 @@ func LoadExternalModels(r io.Reader) ([]ExternalModel, error) {
 	return rv, err
 }
 
+// Sets the top-level inputs and propagates values through the models,
+// in dependency order. The models are modified in place, and any
+// values from an earlier propagation are discarded. See Graph and
+// Evaluation for evaluating without modifying the models.
 func Propagate(models map[string]*Model, topLevel string, inputs map[string]Expression) error {
-	top, ok := models[topLevel]
-	if !ok {
+	if _, ok := models[topLevel]; !ok {
 		return errors.New(fmt.Sprintf("Top-level model %s not found.", topLevel))
 	}
-
-	for name, value := range inputs {
-		top.SetInput(name, "external", value.Value(*top))
+	for _, m := range models {
+		m.Reset()
 	}
 
 	sorted, sortErr := ModelOrder(models)
 	if sortErr != nil {
 		return sortErr
 	}
+	propagate(models, sorted, topLevel, inputs)
+	return nil
+}
+
+// Propagates values through models that have already been sorted in
+// dependency order.
+func propagate(models map[string]*Model, sorted []*Model, topLevel string, inputs map[string]Expression) {
+	top := models[topLevel]
+	for name, value := range inputs {
+		top.setInputFrom(name, "external", value, *top)
+	}
+
 	for _, model := range sorted {
 		for _, val := range model.Variables {
 			_ = val.Value(*model)
 		}
 		model.PropagateOutputs(models)
 	}
-	
-	return nil
 }
 
-func PrintModel( w io.Writer, m *Model) {
+// Returns a comment suffix describing the range of a value, if it is
+// anything other than a single point.
+func rangeComment(r Interval) string {
+	if r.Wide() {
+		return fmt.Sprintf(", range %s", r)
+	}
+	return ""
+}
+
+// Returns the expression for the replica count of a model.
+func replicaExpr(m *Model) Expression {
+	replicas, ok := m.Resources["replicas"]
+	if !ok {
+		replicas = constant{1.0}
+	}
+	return replicas
+}
+
+// Prints an evaluated model, with its monthly cost if there is any
+// pricing.
+func PrintModel( w io.Writer, m *Model, pricing *Pricing) {
 	fmt.Fprintf(w, "- name: %s\n", m.Name)
 	if len(m.Inputs) > 0 {
 		fmt.Fprintf(w, "  inputs:\n")
 		for name, input := range m.Inputs {
 			fmt.Fprintf(w, "    %s:\n", name)
 			for _, iv := range input.values {
-				fmt.Fprintf(w, "      %f # %s\n", iv.value, iv.source)
+				fmt.Fprintf(w, "      %f # %s%s\n", iv.value, iv.source, rangeComment(iv.span))
 			}
 		}
 	}
 	fmt.Fprintf(w, "  resources:\n")
 	if ram, rOK := m.Resources["ram"]; rOK {
-		fmt.Fprintf(w, "    ram: %f # per replica\n", ram.Value(*m))
+		fmt.Fprintf(w, "    ram: %f # per replica%s\n", ram.Value(*m), rangeComment(ram.Range(*m)))
 	}
 	if cores, cOK := m.Resources["cpu"]; cOK {
-		fmt.Fprintf(w, "    cpu: %f # per replica\n", cores.Value(*m))
+		fmt.Fprintf(w, "    cpu: %f # per replica%s\n", cores.Value(*m), rangeComment(cores.Range(*m)))
 	}
-	replicas, repOK := m.Resources["replicas"]
-	if !repOK {
-		replicas = constant{1.0}
+	comments := []string{}
+	if !m.Policy.IsZero() {
+		comments = append(comments, fmt.Sprintf("%.0f before policy %s", rawReplicaCount(m), m.Policy))
+	}
+	if rr := replicaRange(m); rr.Wide() {
+		comments = append(comments, fmt.Sprintf("range %.0f..%.0f", rr.Min, rr.Max))
+	}
+	if len(comments) > 0 {
+		fmt.Fprintf(w, "    replicas: %.0f # %s\n", replicaCount(m), strings.Join(comments, "; "))
+	} else {
+		fmt.Fprintf(w, "    replicas: %.0f\n", replicaCount(m))
+	}
+	if pricing != nil {
+		printCost(w, "  ", pricing.ModelCost(m))
 	}
-	r := math.Ceil(replicas.Value(*m))
-	fmt.Fprintf(w, "    replicas: %.0f\n", r)
 }
 
 func allRAM(m *Model) float64 {
 	ram, ok := m.Resources["ram"]
 	if ok {
-		r, ok2 := m.Resources["replicas"]
-		if !ok2 {
-			r = constant{1.0}
-		}
-		replicas := math.Ceil(r.Value(*m))
-		return ram.Value(*m) * replicas
+		return ram.Value(*m) * replicaCount(m)
 	}
 	return 0
 }
@@ -291,23 +406,69 @@ func allRAM(m *Model) float64 {
 func allCPU(m *Model) float64 {
 	cpu, ok := m.Resources["cpu"]
 	if ok {
-		r, ok2 := m.Resources["replicas"]
-		if !ok2 {
-			r = constant{1.0}
-		}
-		replicas := math.Ceil(r.Value(*m))
-		return cpu.Value(*m) * replicas
+		return cpu.Value(*m) * replicaCount(m)
 	}
 	return 0
 }
 
-func PrintModels(w io.Writer, models map[string]*Model) {
+// Returns the range of a resource across all replicas of a model.
+func allRange(m *Model, resource string) Interval {
+	e, ok := m.Resources[resource]
+	if ok {
+		return e.Range(*m).Mul(replicaRange(m))
+	}
+	return point(0)
+}
+
+// Returns the total RAM and CPU across all replicas of all models.
+func Totals(models map[string]*Model) (float64, float64) {
 	ram := 0.0
 	cpu := 0.0
 	for _, model := range models {
 		ram += allRAM(model)
 		cpu += allCPU(model)
-		PrintModel(w, model)
 	}
-	fmt.Fprintf(w, "\ntotals:\n ram: %f\n cpu: %f\n", ram, cpu)
+	return ram, cpu
+}
+
+// Returns the ranges of total RAM and CPU across all replicas of all
+// models.
+func TotalRanges(models map[string]*Model) (Interval, Interval) {
+	ram := point(0)
+	cpu := point(0)
+	for _, model := range models {
+		ram = ram.Add(allRange(model, "ram"))
+		cpu = cpu.Add(allRange(model, "cpu"))
+	}
+	return ram, cpu
+}
+
+// Prints a total, with its range as a comment if it has one.
+func printTotal(w io.Writer, name string, v float64, r Interval) {
+	if r.Wide() {
+		fmt.Fprintf(w, " %s: %f # range %s\n", name, v, r)
+	} else {
+		fmt.Fprintf(w, " %s: %f\n", name, v)
+	}
+}
+
+func PrintModels(w io.Writer, models map[string]*Model, pricing *Pricing) {
+	for _, model := range models {
+		PrintModel(w, model, pricing)
+	}
+	fmt.Fprintf(w, "\n")
+	PrintTotals(w, models, pricing)
+}
+
+// Prints the total RAM and CPU across all replicas of all models, and
+// the total monthly cost if there is any pricing.
+func PrintTotals(w io.Writer, models map[string]*Model, pricing *Pricing) {
+	ram, cpu := Totals(models)
+	ramRange, cpuRange := TotalRanges(models)
+	fmt.Fprintf(w, "totals:\n")
+	printTotal(w, "ram", ram, ramRange)
+	printTotal(w, "cpu", cpu, cpuRange)
+	if pricing != nil {
+		printCost(w, " ", pricing.TotalCost(models))
+	}
 }
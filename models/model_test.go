@@ -1,6 +1,7 @@
 package models
 
 import (
+	"fmt"
 	//"strings"
 	"testing"
 )
@@ -121,6 +122,30 @@ func TestTopoSort(t *testing.T) {
 	}
 }
 
+func TestTopoLayers(t *testing.T) {
+	deps := map[string][]string{
+		"z": {"x", "y"},
+		"x": {"a"},
+		"y": {"a", "b"},
+		"b": {"a"},
+		"a": {},
+	}
+	seen, err := topoLayers(deps)
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	expected := [][]string{{"a"}, {"b", "x"}, {"y"}, {"z"}}
+	if fmt.Sprint(seen) != fmt.Sprint(expected) {
+		t.Errorf("Saw layers %v, expected %v", seen, expected)
+	}
+
+	// A circle next to an independent model must not loop forever
+	circular := map[string][]string{"a": {"b"}, "b": {"a"}, "c": {}}
+	if _, err := topoLayers(circular); err == nil {
+		t.Errorf("Expected an error for circular dependencies")
+	}
+}
+
 func TestModelsToDepMap(t *testing.T) {
 	m1 := Model{
 		Name: "TestTop",
@@ -214,10 +239,13 @@ func cmpModels(a, b *Model) string {
 		if aOK != bOK {
 			return "incommensurate resources"
 		}
-		if !compareExpr(aVal, bVal) {
+		if aOK && !compareExpr(aVal, bVal) {
 			return "resource expression differ"
 		}
 	}
+	if a.Policy != b.Policy {
+		return "policies differ"
+	}
 	
 	return ""
 }
@@ -251,13 +279,28 @@ func TestModelFromExternal(t *testing.T) {
 		},
 	}
 
-	seen := ModelFromExternal(ext)
+	seen, err := ModelFromExternal(ext)
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
 
 	if status := cmpModels(seen, &expected); status != "" {
 		t.Errorf("Model conversion failed, %s.\n%v\n%v", status, seen, &expected)
 	}
 }
 
+func TestModelFromExternalErrors(t *testing.T) {
+	for ix, ext := range []ExternalModel{
+		{Name: "test", Resources: map[string]string{"replicas": "qps /"}},
+		{Name: "test", Variables: map[string]string{"foo": "3 *"}},
+		{Name: "test", Outputs: []ExternalOutput{{"back", "qps", "qps +"}}},
+	} {
+		if _, err := ModelFromExternal(ext); err == nil {
+			t.Errorf("test %d, expected an error for a bad expression", ix)
+		}
+	}
+}
+
 func TestUnmarshal(t *testing.T) {
 	input := `name: test
 inputs:
@@ -282,3 +325,37 @@ resources:
 		t.Errorf("Unmarshal saw an error, %s", err)
 	}
 }
+
+func TestPropagateRanges(t *testing.T) {
+	ext := []ExternalModel{
+		{
+			Name: "top",
+			Inputs: []string{"qps"},
+			Outputs: []ExternalOutput{{"backend", "qps", "qps * 0.5..1"}},
+		},
+		{
+			Name: "backend",
+			Inputs: []string{"qps"},
+			Resources: map[string]string{"replicas": "qps / 100"},
+		},
+	}
+	models := map[string]*Model{}
+	for _, e := range ext {
+		m, err := ModelFromExternal(e)
+		if err != nil {
+			t.Fatalf("Unexpected error, %s", err)
+		}
+		models[e.Name] = m
+	}
+	inputs := map[string]Expression{"qps": span{1000, 2000}}
+	if err := Propagate(models, "top", inputs); err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+
+	backend := models["backend"]
+	seen := backend.Resources["replicas"].Range(*backend).Ceil()
+	expected := Interval{5, 20}
+	if seen != expected {
+		t.Errorf("Saw replicas %v, expected %v", seen, expected)
+	}
+}
@@ -0,0 +1,177 @@
+// Monte Carlo evaluation of models with uncertain values
+
+package models
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Selected percentiles of a set of samples
+type Percentiles struct {
+	P50 float64
+	P90 float64
+	P99 float64
+}
+
+// Sampled replica counts and resource totals for a single model
+type ModelSamples struct {
+	Model    string
+	Replicas Percentiles
+	CPU      Percentiles
+	RAM      Percentiles
+}
+
+// The result of a Monte Carlo run, models are sorted by name.
+type MonteCarloReport struct {
+	Samples int
+	Seed    int64
+	Models  []ModelSamples
+	CPU     Percentiles
+	RAM     Percentiles
+}
+
+// Returns the nearest-rank percentile p (0 < p <= 1) of a set of
+// values. The values are sorted in place.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	ix := int(math.Ceil(p*float64(len(values)))) - 1
+	if ix < 0 {
+		ix = 0
+	}
+	return values[ix]
+}
+
+func newPercentiles(values []float64) Percentiles {
+	return Percentiles{
+		percentile(values, 0.5),
+		percentile(values, 0.9),
+		percentile(values, 0.99),
+	}
+}
+
+// Returns the keys of a map, sorted, so that samples are drawn in a
+// reproducible order.
+func sortedModelNames(models map[string]*Model) []string {
+	rv := []string{}
+	for name, _ := range models {
+		rv = append(rv, name)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+func sortedVariableNames(m *Model) []string {
+	rv := []string{}
+	for name, _ := range m.Variables {
+		rv = append(rv, name)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// Returns the scenario for a single sample, with every distribution
+// replaced by a value drawn from it.
+func sampleScenario(g *Graph, inputs map[string]Expression, r *rand.Rand) Scenario {
+	rv := Scenario{make(map[string]Expression), make(map[string]Expression)}
+	for _, name := range sortedExpressionNames(inputs) {
+		rv.Inputs[name] = inputs[name]
+		if d, ok := inputs[name].(distribution); ok {
+			rv.Inputs[name] = constant{d.Sample(r)}
+		}
+	}
+	for _, mName := range g.Names() {
+		m, _ := g.Model(mName)
+		for _, vName := range sortedVariableNames(m) {
+			if d, ok := m.Variables[vName].expr.(distribution); ok {
+				rv.Overrides[mName+"."+vName] = constant{d.Sample(r)}
+			}
+		}
+	}
+	return rv
+}
+
+// Returns the point values of a set of expressions that do not refer
+// to anything, like sampled inputs.
+func pointValues(exprs map[string]Expression) map[string]float64 {
+	rv := make(map[string]float64)
+	for name, e := range exprs {
+		rv[name] = e.Value(Model{})
+	}
+	return rv
+}
+
+// Evaluates the models the given number of times, each time drawing
+// a new sample from every distribution used for a variable or a
+// top-level input. The same seed always gives the same report.
+func MonteCarlo(g *Graph, inputs map[string]Expression, samples int, seed int64) (MonteCarloReport, error) {
+	rv := MonteCarloReport{Samples: samples, Seed: seed}
+	r := rand.New(rand.NewSource(seed))
+	names := g.Names()
+	replicas := make(map[string][]float64)
+	cpus := make(map[string][]float64)
+	rams := make(map[string][]float64)
+	totalCPU := []float64{}
+	totalRAM := []float64{}
+
+	c, err := g.Compile()
+	if err != nil {
+		return rv, err
+	}
+	for i := 0; i < samples; i++ {
+		s := sampleScenario(g, inputs, r)
+		results, err := c.Evaluate(pointValues(s.Inputs), pointValues(s.Overrides))
+		if err != nil {
+			return rv, err
+		}
+		ram := 0.0
+		cpu := 0.0
+		for _, result := range results {
+			replicas[result.Model] = append(replicas[result.Model], result.Replicas)
+			cpus[result.Model] = append(cpus[result.Model], result.TotalCPU())
+			rams[result.Model] = append(rams[result.Model], result.TotalRAM())
+			ram += result.TotalRAM()
+			cpu += result.TotalCPU()
+		}
+		totalRAM = append(totalRAM, ram)
+		totalCPU = append(totalCPU, cpu)
+	}
+
+	for _, name := range names {
+		rv.Models = append(rv.Models, ModelSamples{
+			Model:    name,
+			Replicas: newPercentiles(replicas[name]),
+			CPU:      newPercentiles(cpus[name]),
+			RAM:      newPercentiles(rams[name]),
+		})
+	}
+	rv.CPU = newPercentiles(totalCPU)
+	rv.RAM = newPercentiles(totalRAM)
+
+	return rv, nil
+}
+
+func printPercentiles(w io.Writer, indent, name string, p Percentiles) {
+	fmt.Fprintf(w, "%s%s: {p50: %f, p90: %f, p99: %f}\n", indent, name, p.P50, p.P90, p.P99)
+}
+
+// Prints a Monte Carlo report. Resources are totals across all
+// replicas of a model.
+func PrintMonteCarlo(w io.Writer, report MonteCarloReport) {
+	fmt.Fprintf(w, "# %d samples, seed %d\n", report.Samples, report.Seed)
+	for _, m := range report.Models {
+		fmt.Fprintf(w, "- name: %s\n", m.Model)
+		printPercentiles(w, "  ", "replicas", m.Replicas)
+		printPercentiles(w, "  ", "ram", m.RAM)
+		printPercentiles(w, "  ", "cpu", m.CPU)
+	}
+	fmt.Fprintf(w, "\ntotals:\n")
+	printPercentiles(w, " ", "ram", report.RAM)
+	printPercentiles(w, " ", "cpu", report.CPU)
+}
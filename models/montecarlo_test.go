@@ -0,0 +1,62 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestPercentile(t *testing.T) {
+	values := []float64{5, 1, 4, 2, 3, 6, 7, 8, 9, 10}
+	td := []struct {
+		p float64
+		e float64
+	}{
+		{0.5, 5},
+		{0.9, 9},
+		{0.99, 10},
+		{0.01, 1},
+	}
+
+	for _, d := range td {
+		if seen := percentile(values, d.p); seen != d.e {
+			t.Errorf("percentile %f, saw %f, expected %f", d.p, seen, d.e)
+		}
+	}
+}
+
+func monteCarloModels() map[string]*Model {
+	top := New("top")
+	top.NewInput("qps")
+	top.Variables["qps_per_replica"] = newVariable("qps_per_replica", distribution{"uniform", []float64{50, 150}})
+	top.Resources["cpu"] = constant{2}
+	top.Resources["replicas"] = operation{"/", reference{"qps"}, reference{"qps_per_replica"}}
+
+	return map[string]*Model{"top": top}
+}
+
+func TestMonteCarlo(t *testing.T) {
+	g, err := NewGraph(monteCarloModels(), "top")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	inputs := map[string]Expression{"qps": distribution{"normal", []float64{1000, 100}}}
+
+	first, err := MonteCarlo(g, inputs, 200, 42)
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	second, _ := MonteCarlo(g, inputs, 200, 42)
+	if len(first.Models) != 1 || first.Models[0] != second.Models[0] || first.CPU != second.CPU {
+		t.Errorf("Runs with the same seed differ, %v and %v", first, second)
+	}
+
+	replicas := first.Models[0].Replicas
+	if !(replicas.P50 <= replicas.P90 && replicas.P90 <= replicas.P99) {
+		t.Errorf("Percentiles out of order, %v", replicas)
+	}
+	if replicas.P50 < 7 || replicas.P99 > 26 {
+		t.Errorf("Implausible replica percentiles, %v", replicas)
+	}
+	if first.CPU.P50 != 2*replicas.P50 {
+		t.Errorf("Saw cpu p50 %f, expected %f", first.CPU.P50, 2*replicas.P50)
+	}
+}
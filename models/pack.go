@@ -0,0 +1,285 @@
+// Packing model replicas onto machines of given shapes
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// A machine or VM shape, and what it costs per hour
+type Shape struct {
+	Name     string  `yaml:"name"`
+	Cores    float64 `yaml:"cores"`
+	RAMGiB   float64 `yaml:"ram_gib"`
+	DiskGiB  float64 `yaml:"disk_gib"`
+	CostHour float64 `yaml:"cost_hour"`
+}
+
+// Constraints on where the replicas of a model can go. With Spread,
+// no two replicas of the model share a machine. If Shapes is empty,
+// any shape can be used.
+type Placement struct {
+	Spread bool     `yaml:"spread"`
+	Shapes []string `yaml:"shapes"`
+}
+
+// The machine shapes available, and the placement constraints of
+// models, keyed by model name.
+type Catalogue struct {
+	Shapes    []Shape              `yaml:"shapes"`
+	Placement map[string]Placement `yaml:"placement"`
+}
+
+// The machines of a single shape needed by a packing
+type ShapeCount struct {
+	Shape    string
+	Machines int
+	// Resources used, and available, across all the machines
+	Cores     float64
+	RAM       float64
+	MaxCores  float64
+	MaxRAM    float64
+	CostMonth float64
+}
+
+// The machines needed to host every replica of every model, by shape
+// sorted by name.
+type Packing struct {
+	Shapes    []ShapeCount
+	Machines  int
+	CostMonth float64
+}
+
+// Parses a catalogue, checking that every shape has a unique name,
+// some cores and RAM, and that placements only name known shapes.
+func ParseCatalogue(data []byte) (Catalogue, error) {
+	rv := Catalogue{}
+	if err := yaml.UnmarshalStrict(data, &rv); err != nil {
+		return rv, errors.New(fmt.Sprintf("Bad catalogue, %s", err))
+	}
+	if len(rv.Shapes) == 0 {
+		return rv, errors.New("Catalogue has no shapes")
+	}
+	known := make(map[string]bool)
+	for _, s := range rv.Shapes {
+		if known[s.Name] {
+			return rv, errors.New(fmt.Sprintf("Shape %s defined more than once", s.Name))
+		}
+		if s.Cores <= 0 || s.RAMGiB <= 0 {
+			return rv, errors.New(fmt.Sprintf("Shape %s needs cores and ram_gib", s.Name))
+		}
+		known[s.Name] = true
+	}
+	for model, p := range rv.Placement {
+		for _, s := range p.Shapes {
+			if !known[s] {
+				return rv, errors.New(fmt.Sprintf("Placement of %s uses unknown shape %s", model, s))
+			}
+		}
+	}
+	return rv, nil
+}
+
+// Loads a catalogue file, see ParseCatalogue.
+func LoadCatalogue(filename string) (Catalogue, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return Catalogue{}, err
+	}
+	return ParseCatalogue(data)
+}
+
+// A single replica to place, RAM and disk in bytes
+type packItem struct {
+	model string
+	cores float64
+	ram   float64
+	disk  float64
+}
+
+// A machine being packed, with the resources left on it
+type machine struct {
+	shape  *Shape
+	cores  float64
+	ram    float64
+	disk   float64
+	models map[string]bool
+}
+
+func newMachine(s *Shape) *machine {
+	return &machine{s, s.Cores, s.RAMGiB * bytesPerGiB, s.DiskGiB * bytesPerGiB, make(map[string]bool)}
+}
+
+// Returns true if an item fits in an empty machine of the shape
+func (s *Shape) fits(item packItem) bool {
+	return item.cores <= s.Cores && item.ram <= s.RAMGiB*bytesPerGiB && item.disk <= s.DiskGiB*bytesPerGiB
+}
+
+func (m *machine) fits(item packItem, spread bool) bool {
+	if spread && m.models[item.model] {
+		return false
+	}
+	return item.cores <= m.cores && item.ram <= m.ram && item.disk <= m.disk
+}
+
+func (m *machine) add(item packItem) {
+	m.cores -= item.cores
+	m.ram -= item.ram
+	m.disk -= item.disk
+	m.models[item.model] = true
+}
+
+// Returns true if a model may be placed on a shape
+func (p Placement) allows(shape string) bool {
+	if len(p.Shapes) == 0 {
+		return true
+	}
+	for _, s := range p.Shapes {
+		if s == shape {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns one item for every replica of every model using any CPU,
+// RAM or disk, largest first.
+func (c Catalogue) items(models map[string]*Model) []packItem {
+	rv := []packItem{}
+	for _, name := range sortedModelNames(models) {
+		m := models[name]
+		item := packItem{name, 0, 0, 0}
+		if cpu, ok := m.Resources["cpu"]; ok {
+			item.cores = cpu.Value(*m)
+		}
+		if ram, ok := m.Resources["ram"]; ok {
+			item.ram = ram.Value(*m)
+		}
+		if disk, ok := m.Resources["disk"]; ok {
+			item.disk = disk.Value(*m)
+		}
+		if item.cores == 0 && item.ram == 0 && item.disk == 0 {
+			continue
+		}
+		replicas := int(replicaCount(m))
+		for i := 0; i < replicas; i++ {
+			rv = append(rv, item)
+		}
+	}
+	sort.SliceStable(rv, func(i, j int) bool {
+		return rv[i].cores+rv[i].ram/bytesPerGiB > rv[j].cores+rv[j].ram/bytesPerGiB
+	})
+	return rv
+}
+
+// Packs items first-fit, opening a machine of the preferred shape
+// where allowed, and otherwise of the cheapest allowed shape the item
+// fits in.
+func (c Catalogue) packWith(items []packItem, preferred *Shape) ([]*machine, error) {
+	machines := []*machine{}
+	for _, item := range items {
+		placement := c.Placement[item.model]
+		placed := false
+		for _, m := range machines {
+			if placement.allows(m.shape.Name) && m.fits(item, placement.Spread) {
+				m.add(item)
+				placed = true
+				break
+			}
+		}
+		if placed {
+			continue
+		}
+		var shape *Shape
+		if placement.allows(preferred.Name) && preferred.fits(item) {
+			shape = preferred
+		} else {
+			for ix := range c.Shapes {
+				s := &c.Shapes[ix]
+				if placement.allows(s.Name) && s.fits(item) && (shape == nil || s.CostHour < shape.CostHour) {
+					shape = s
+				}
+			}
+		}
+		if shape == nil {
+			return nil, errors.New(fmt.Sprintf("A replica of %s does not fit on any allowed shape", item.model))
+		}
+		m := newMachine(shape)
+		m.add(item)
+		machines = append(machines, m)
+	}
+	return machines, nil
+}
+
+// Summarizes a set of packed machines
+func newPacking(machines []*machine) Packing {
+	counts := make(map[string]*ShapeCount)
+	rv := Packing{Shapes: []ShapeCount{}}
+	for _, m := range machines {
+		sc, ok := counts[m.shape.Name]
+		if !ok {
+			sc = &ShapeCount{Shape: m.shape.Name}
+			counts[m.shape.Name] = sc
+		}
+		sc.Machines++
+		sc.Cores += m.shape.Cores - m.cores
+		sc.RAM += m.shape.RAMGiB*bytesPerGiB - m.ram
+		sc.MaxCores += m.shape.Cores
+		sc.MaxRAM += m.shape.RAMGiB * bytesPerGiB
+		sc.CostMonth += m.shape.CostHour * HoursPerMonth
+		rv.Machines++
+		rv.CostMonth += m.shape.CostHour * HoursPerMonth
+	}
+	names := []string{}
+	for name, _ := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rv.Shapes = append(rv.Shapes, *counts[name])
+	}
+	return rv
+}
+
+// Computes the machines needed to host every replica of the evaluated
+// models, honouring their placements. Replicas are packed largest
+// first, trying each shape in turn as the one to open new machines
+// with, and the cheapest packing wins, or the one with the fewest
+// machines if they cost the same. Models using no CPU, RAM or disk
+// need no machines.
+func (c Catalogue) Pack(models map[string]*Model) (Packing, error) {
+	items := c.items(models)
+	var best *Packing
+	var lastErr error
+	for ix := range c.Shapes {
+		machines, err := c.packWith(items, &c.Shapes[ix])
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		p := newPacking(machines)
+		if best == nil || p.CostMonth < best.CostMonth || (p.CostMonth == best.CostMonth && p.Machines < best.Machines) {
+			best = &p
+		}
+	}
+	if best == nil {
+		return Packing{}, lastErr
+	}
+	return *best, nil
+}
+
+// Prints the machines needed by a packing, and how much of them is
+// used.
+func PrintPacking(w io.Writer, p Packing) {
+	fmt.Fprintf(w, "\nmachines:\n")
+	for _, s := range p.Shapes {
+		fmt.Fprintf(w, " %s: %d # %.0f%% of cores, %.0f%% of ram used, %.2f per month\n", s.Shape, s.Machines, 100*s.Cores/s.MaxCores, 100*s.RAM/s.MaxRAM, s.CostMonth)
+	}
+	fmt.Fprintf(w, " total: %d # %.2f per month\n", p.Machines, p.CostMonth)
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testCatalogue = `shapes:
+ - name: small
+   cores: 4
+   ram_gib: 16
+   cost_hour: 0.2
+ - name: large
+   cores: 16
+   ram_gib: 64
+   disk_gib: 1000
+   cost_hour: 0.6
+placement:
+  db:
+    spread: true
+    shapes: [large]
+`
+
+// Returns a model with the given replicas, each using cores and GiB
+// of RAM.
+func packModel(name string, replicas, cores, ram float64) *Model {
+	m := New(name)
+	m.Resources["replicas"] = constant{replicas}
+	m.Resources["cpu"] = constant{cores}
+	m.Resources["ram"] = constant{ram * bytesPerGiB}
+	return m
+}
+
+func TestParseCatalogue(t *testing.T) {
+	c, err := ParseCatalogue([]byte(testCatalogue))
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if len(c.Shapes) != 2 || c.Shapes[1].DiskGiB != 1000 || !c.Placement["db"].Spread {
+		t.Errorf("Unexpected catalogue %v", c)
+	}
+
+	bad := []string{
+		"shapes: []\n",
+		"shapes:\n - name: a\n   cores: 1\n",
+		"shapes:\n - {name: a, cores: 1, ram_gib: 1}\n - {name: a, cores: 2, ram_gib: 2}\n",
+		"shapes:\n - {name: a, cores: 1, ram_gib: 1}\nplacement:\n  db: {shapes: [b]}\n",
+		"shapes:\n - {name: a, cores: 1, ram_gib: 1, gpus: 2}\n",
+	}
+	for ix, data := range bad {
+		if _, err := ParseCatalogue([]byte(data)); err == nil {
+			t.Errorf("test %d, expected an error", ix)
+		}
+	}
+}
+
+func TestPack(t *testing.T) {
+	c, _ := ParseCatalogue([]byte(testCatalogue))
+
+	td := []struct {
+		models   []*Model
+		err      bool
+		shapes   map[string]int
+		machines int
+	}{
+		// 8 replicas of 2 cores fit on one large machine more cheaply
+		// than on 4 small ones
+		{[]*Model{packModel("web", 8, 2, 4)}, false, map[string]int{"large": 1}, 1},
+		// A single small replica only needs a small machine
+		{[]*Model{packModel("web", 1, 2, 4)}, false, map[string]int{"small": 1}, 1},
+		// Spread replicas get a machine each, and only large ones
+		{[]*Model{packModel("db", 3, 1, 1)}, false, map[string]int{"large": 3}, 3},
+		// Other models fill up the space next to spread replicas
+		{[]*Model{packModel("db", 2, 8, 32), packModel("web", 4, 4, 16)}, false, map[string]int{"large": 2}, 2},
+		{[]*Model{packModel("web", 1, 32, 4)}, true, nil, 0},
+		{[]*Model{New("abstract")}, false, map[string]int{}, 0},
+	}
+
+	for ix, d := range td {
+		models := make(map[string]*Model)
+		for _, m := range d.models {
+			models[m.Name] = m
+		}
+		p, err := c.Pack(models)
+		if (err != nil) != d.err {
+			t.Errorf("test %d, saw error %v, expected error %v", ix, err, d.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if p.Machines != d.machines || len(p.Shapes) != len(d.shapes) {
+			t.Errorf("test %d, saw %v, expected %d machines, %v", ix, p, d.machines, d.shapes)
+			continue
+		}
+		for _, s := range p.Shapes {
+			if s.Machines != d.shapes[s.Shape] {
+				t.Errorf("test %d, saw %d %s machines, expected %d", ix, s.Machines, s.Shape, d.shapes[s.Shape])
+			}
+		}
+	}
+}
+
+func TestPrintPacking(t *testing.T) {
+	c, _ := ParseCatalogue([]byte(testCatalogue))
+	p, _ := c.Pack(map[string]*Model{"web": packModel("web", 2, 2, 8)})
+	var buf bytes.Buffer
+	PrintPacking(&buf, p)
+	expected := "\nmachines:\n small: 1 # 100% of cores, 100% of ram used, 146.00 per month\n total: 1 # 146.00 per month\n"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("Saw\n%s\nexpected\n%s", buf.String(), expected)
+	}
+}
@@ -0,0 +1,86 @@
+// Parallel evaluation of independent models
+
+package models
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// Evaluates a single model, feeds its outputs to their backends and
+// records its result. Only the backends and the results are shared
+// with other goroutines, so they are only modified holding the lock.
+func evaluateModel(m *Model, run map[string]*Model, results map[string]Result, lock *sync.Mutex) {
+	for _, val := range m.Variables {
+		_ = val.Value(*m)
+	}
+	for _, o := range m.Outputs {
+		iv := inputValue{source: m.Name, value: o.value.Value(*m), span: o.value.Range(*m)}
+		lock.Lock()
+		run[o.backend].addInputValue(o.input, iv)
+		lock.Unlock()
+	}
+	r := newResult(m)
+	lock.Lock()
+	results[m.Name] = r
+	lock.Unlock()
+}
+
+// Evaluates the graph from scratch with the current scenario, like
+// Run, but evaluating the models in each layer of the graph
+// concurrently, using at most the given number of workers. With no
+// workers given, one per CPU is used. If the context is cancelled,
+// the evaluation stops and the results of any earlier run are kept.
+func (e *Evaluation) RunParallel(ctx context.Context, workers int) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	run, err := e.prepare()
+	if err != nil {
+		return err
+	}
+	top := run[e.graph.top]
+	for name, value := range e.scenario.Inputs {
+		top.setInputFrom(name, "external", value, *top)
+	}
+
+	results := make(map[string]Result)
+	var lock sync.Mutex
+	for _, layer := range e.graph.layers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		jobs := make(chan *Model)
+		var wg sync.WaitGroup
+		n := workers
+		if len(layer) < n {
+			n = len(layer)
+		}
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for m := range jobs {
+					evaluateModel(m, run, results, &lock)
+				}
+			}()
+		}
+	feed:
+		for _, name := range layer {
+			select {
+			case jobs <- run[name]:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		close(jobs)
+		wg.Wait()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	e.finish(run, results)
+	return nil
+}
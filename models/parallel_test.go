@@ -0,0 +1,112 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// Builds a graph of the given number of layers, each with width
+// models, where every model feeds two models in the next layer.
+func wideModels(layers, width int) map[string]*Model {
+	rv := make(map[string]*Model)
+	name := func(l, w int) string {
+		return fmt.Sprintf("m%d_%d", l, w)
+	}
+	for l := 0; l < layers; l++ {
+		for w := 0; w < width; w++ {
+			m := New(name(l, w))
+			m.NewInput("qps")
+			m.Variables["scaled"] = newVariable("scaled", operation{"*", reference{"qps"}, constant{float64(w + 1)}})
+			m.Resources["cpu"] = constant{0.5}
+			m.Resources["replicas"] = operation{"/", reference{"scaled"}, constant{100}}
+			if l+1 < layers {
+				m.NewOutput(name(l+1, w), "qps", operation{"/", reference{"qps"}, constant{2}})
+				m.NewOutput(name(l+1, (w+1)%width), "qps", operation{"/", reference{"qps"}, constant{2}})
+			}
+			rv[m.Name] = m
+		}
+	}
+	top := New("top")
+	top.NewInput("qps")
+	for w := 0; w < width; w++ {
+		top.NewOutput(name(0, w), "qps", reference{"qps"})
+	}
+	rv["top"] = top
+	return rv
+}
+
+func TestRunParallel(t *testing.T) {
+	g, err := NewGraph(wideModels(5, 20), "top")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if layers := g.Layers(); len(layers) != 6 || len(layers[1]) != 20 {
+		t.Fatalf("Unexpected layers, %v", layers)
+	}
+	s := Scenario{Inputs: map[string]Expression{"qps": constant{10000}}}
+
+	serial, err := g.Run(s)
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	for _, workers := range []int{0, 1, 4, 100} {
+		parallel := g.NewEvaluation(s)
+		if err := parallel.RunParallel(context.Background(), workers); err != nil {
+			t.Fatalf("%d workers, unexpected error, %s", workers, err)
+		}
+		for _, expected := range serial.Results() {
+			seen, _ := parallel.Result(expected.Model)
+			if seen != expected {
+				t.Errorf("%d workers, saw %v, expected %v", workers, seen, expected)
+			}
+		}
+	}
+}
+
+func TestRunParallelThenRecompute(t *testing.T) {
+	g, _ := NewGraph(wideModels(3, 5), "top")
+	e := g.NewEvaluation(Scenario{})
+	e.SetInput("qps", constant{100})
+	if err := e.RunParallel(context.Background(), 2); err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	// The parallel run already used the new input
+	recomputed, err := e.Recompute()
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if len(recomputed) != 0 {
+		t.Errorf("Saw %v recomputed, expected nothing", recomputed)
+	}
+}
+
+func TestRunParallelCancelled(t *testing.T) {
+	g, _ := NewGraph(wideModels(3, 5), "top")
+	e := g.NewEvaluation(Scenario{Inputs: map[string]Expression{"qps": constant{100}}})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := e.RunParallel(ctx, 2); err != context.Canceled {
+		t.Errorf("Saw error %v, expected %v", err, context.Canceled)
+	}
+	if e.Models() != nil {
+		t.Errorf("Cancelled evaluation recorded results")
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	g, _ := NewGraph(wideModels(10, 200), "top")
+	e := g.NewEvaluation(Scenario{Inputs: map[string]Expression{"qps": constant{10000}}})
+	for i := 0; i < b.N; i++ {
+		e.Run()
+	}
+}
+
+func BenchmarkRunParallel(b *testing.B) {
+	g, _ := NewGraph(wideModels(10, 200), "top")
+	e := g.NewEvaluation(Scenario{Inputs: map[string]Expression{"qps": constant{10000}}})
+	for i := 0; i < b.N; i++ {
+		e.RunParallel(context.Background(), 0)
+	}
+}
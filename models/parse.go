@@ -3,6 +3,8 @@ package models
 import (
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -18,34 +20,56 @@ type token struct {
 	repr string
 }
 
-func tokenize(s string) <-chan token {
-	c := make(chan token)
-
-	go tokenizeInner(s, c)
-	return c
-}
-
-func tokenizeInner(s string, c chan<- token) {
+// Splits an expression into tokens
+func tokenize(s string) []token {
+	rv := []token{}
 	pos := 0
+	operand := false
 
 	for pos >= 0 {
-		next, t := oneToken(s, pos)
+		next, t := oneToken(s, pos, operand)
 		if next >= 0 {
-			c <- t
+			rv = append(rv, t)
 		}
+		operand = t.t == number || t.t == ref || t.t == closed
 		pos = next
 	}
-	close(c)
+	return rv
+}
+
+// A cursor over the tokens of an expression
+type lexer struct {
+	tokens []token
+	pos    int
+}
+
+// Returns the next token, and false if there are no tokens left
+func (l *lexer) next() (token, bool) {
+	if l.pos >= len(l.tokens) {
+		return token{}, false
+	}
+	t := l.tokens[l.pos]
+	l.pos++
+	return t, true
 }
 
-func oneToken(s string, p int) (int, token) {
+// Returns true if the character can start a number
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c == '.'
+}
+
+// Returns the position after the next token, and the token. A '-'
+// directly after an operand is a subtraction, otherwise a '-' followed
+// by a digit starts a negative number.
+func oneToken(s string, p int, operand bool) (int, token) {
 	for ;p < len(s) && s[p] == ' '; p++ {}
 	if p >= len(s) {
 		return -1, token{}
 	}
 	start := p
+	negative := !operand && s[start] == '-' && start+1 < len(s) && isDigit(s[start+1])
 	switch {
-	case s[start] >= '0' && s[start] <= '9' || s[start] == '-':
+	case s[start] >= '0' && s[start] <= '9' || negative:
 		return tokenNumber(s, start)
 	case s[start] == '+':
 		end := start + 1
@@ -82,6 +106,9 @@ func tokenNumber(s string, start int) (int, token) {
 			_ = true
 		case s[end] == '.':
 			_ = true
+		case s[end] == '-' && end-start > 2 && s[end-2:end] == "..":
+			// negative upper bound of a range
+			_ = true
 		default:
 			return end, token{number, s[start:end]}
 		}	
@@ -111,72 +138,106 @@ func tokenReference(s string, start int) (int, token) {
 	return len(s), token{ref, s[start:len(s)]}
 }
 
+// Parses an expression. A distribution, like normal(500, 50), is only
+// allowed as the whole of an expression.
 func Parse(s string) (Expression, error) {
-	return parseInner(tokenize(s), 0)
+	if d, ok, err := parseDistribution(s); ok {
+		return d, err
+	}
+	l := lexer{tokens: tokenize(s)}
+	return parseInner(&l, 0)
+}
+
+// Operator precedence, operators of equal precedence are evaluated
+// left to right.
+var precedence = map[string]int{"+": 5, "-": 5, "*": 10, "/": 10}
+
+// Replaces the top two operands with the top operator applied to them.
+func reduce(ops []operation, output []Expression) ([]operation, []Expression, error) {
+	op := ops[len(ops) - 1]
+	n := len(output)
+	if n < 2 {
+		return nil, nil, errors.New(fmt.Sprintf("Missing operand for %s.", op.operator))
+	}
+	op.right = output[n - 1]
+	op.left = output[n - 2]
+	return ops[:len(ops) - 1], append(output[:n - 2], op), nil
 }
 
-func parseInner(c <-chan token, level int) (Expression, error){
-	precedence := map[string]int{"+": 5, "-": 5, "*": 10, "/": 10}
+// Applies all remaining operators, checking that a single expression
+// remains.
+func finish(ops []operation, output []Expression) (Expression, error) {
+	var err error
+	for len(ops) > 0 {
+		if ops, output, err = reduce(ops, output); err != nil {
+			return constant{-1.0}, err
+		}
+	}
+	if len(output) != 1 {
+		return constant{-1.0}, errors.New("Malformed expression.")
+	}
+	return output[0], nil
+}
+
+func parseInner(l *lexer, level int) (Expression, error){
 	ops := []operation{}
 	output := []Expression{}
-	for t := range c {
+	var err error
+	for t, ok := l.next(); ok; t, ok = l.next() {
 		switch t.t {
 		case number:
-			output = append(output, parseNumber(t))
+			n, err := parseNumber(t)
+			if err != nil {
+				return n, err
+			}
+			output = append(output, n)
 		case operator:
-			for len(ops) > 0 && precedence[ops[len(ops) - 1].operator] > precedence[t.repr] {
-				op := ops[len(ops) - 1]
-				n := len(output)
-				op.right = output[n - 1]
-				op.left = output[n - 2]
-				output = append(output[:n-2], op)
-				ops = ops[:len(ops) - 1]
+			for len(ops) > 0 && precedence[ops[len(ops) - 1].operator] >= precedence[t.repr] {
+				if ops, output, err = reduce(ops, output); err != nil {
+					return constant{-1.0}, err
+				}
 			}
 			op := operation{operator: t.repr}
 			ops = append(ops, op)
 		case open:
-			tmp, err := parseInner(c, level+1)
+			tmp, err := parseInner(l, level+1)
 			if err != nil {
 				return tmp, err
 			}
 			output = append(output, tmp)
 		case closed:
 			if level == 0 {
-				// Make sure the queue is emptied...
-				go func(){
-					for _ = range c {}
-				}()
 				return constant{-1.0}, errors.New("Unexpected close parenthesis.")
 			}
-			for len(ops) > 0 {
-				op := ops[len(ops) - 1]
-				n := len(output)
-				op.right = output[n - 1]
-				op.left = output[n - 2]
-				output = append(output[:n - 2], op)
-				ops = ops[:len(ops) - 1]
-			}
-			return output[0], nil
+			return finish(ops, output)
 		case ref:
 			output = append(output, reference{t.repr})
 		}
 	}
-	for len(ops) > 0 {
-		op := ops[len(ops) - 1]
-		n := len(output)
-		op.right = output[n - 1]
-		op.left = output[n - 2]
-		output = append(output[:n - 2], op)
-		ops = ops[:len(ops) - 1]
+	if level > 0 {
+		return constant{-1.0}, errors.New("Missing close parenthesis.")
 	}
-	return output[0], nil
+	return finish(ops, output)
 }
 
-func parseNumber(t token) constant {
-	var v float64
-	cnt, err := fmt.Sscan(t.repr, &v)
-	if cnt == 1 && err == nil {
-		return constant{v}
+// Parses a number, or a range of numbers like 400..600
+func parseNumber(t token) (Expression, error) {
+	if bounds := strings.Split(t.repr, ".."); len(bounds) == 2 {
+		lo, loErr := parseNumber(token{number, bounds[0]})
+		hi, hiErr := parseNumber(token{number, bounds[1]})
+		if loErr != nil || hiErr != nil {
+			return constant{-1.0}, errors.New(fmt.Sprintf("Bad range %s.", t.repr))
+		}
+		min := lo.Value(Model{})
+		max := hi.Value(Model{})
+		if min > max {
+			min, max = max, min
+		}
+		return span{min, max}, nil
+	}
+	v, err := strconv.ParseFloat(t.repr, 64)
+	if err == nil {
+		return constant{v}, nil
 	}
-	return constant{-1.0}
+	return constant{-1.0}, errors.New(fmt.Sprintf("Bad number %s.", t.repr))
 }
@@ -1,7 +1,9 @@
 package models
 
 import (
+	"runtime"
 	"testing"
+	"time"
 )
 
 func tokenEqual(a, b token) bool {
@@ -32,6 +34,25 @@ func compareExpr(a, b Expression) bool {
 				return compareExpr(o1.left, o2.left) && compareExpr(o1.right, o2.right)
 			}
 		}
+	case span:
+		switch b.(type) {
+		case span: return a.(span) == b.(span)
+		}
+	case distribution:
+		switch b.(type) {
+		case distribution:
+			d1 := a.(distribution)
+			d2 := b.(distribution)
+			if d1.kind != d2.kind || len(d1.params) != len(d2.params) {
+				return false
+			}
+			for ix, p := range d1.params {
+				if p != d2.params[ix] {
+					return false
+				}
+			}
+			return true
+		}
 	case reference:
 		switch b.(type) {
 		case reference:
@@ -55,12 +76,22 @@ func TestTokenizer(t *testing.T) {
 			token{number, "1"}, token{operator, "*"},
 			token{open, "("}, token{closed, ")"},
 			token{operator, "+"}, token{number, "2"}}},
+		{"a-1", []token{token{ref, "a"}, token{operator, "-"}, token{number, "1"}}},
+		{"a - b", []token{token{ref, "a"}, token{operator, "-"}, token{ref, "b"}}},
+		{"-1*(-2)-3", []token{
+			token{number, "-1"}, token{operator, "*"},
+			token{open, "("}, token{number, "-2"}, token{closed, ")"},
+			token{operator, "-"}, token{number, "3"}}},
 	}
 
 	for ix, d := range td {
-		c := tokenize(d.s) 
+		tokens := tokenize(d.s)
+		if len(tokens) != len(d.tokens) {
+			t.Errorf("test %d, saw %d tokens, expected %d", ix, len(tokens), len(d.tokens))
+			continue
+		}
 		for tIx, expected := range d.tokens {
-			seen := <- c
+			seen := tokens[tIx]
 			if !tokenEqual(seen, expected) {
 				t.Errorf("test %d, pos %d, Expected %v, saw %v", ix, tIx, seen, expected)
 			}
@@ -108,6 +139,33 @@ func TestParser(t *testing.T) {
 					right: reference{"b"}},
 				right: constant{3}}},
 		{"1+2)-3", true, constant{1}},
+		{"(1+2", true, constant{1}},
+		{"1 +", true, constant{1}},
+		{"* 2", true, constant{1}},
+		{"1 2", true, constant{1}},
+		{"", true, constant{1}},
+		{"()", true, constant{1}},
+		{"1..", true, constant{1}},
+		{"qps - lightweight", false,
+			operation{
+				operator: "-",
+				left: reference{"qps"},
+				right: reference{"lightweight"}}},
+		{"10-2-3", false,
+			operation{
+				operator: "-",
+				left: operation{
+					operator: "-",
+					left: constant{10},
+					right: constant{2}},
+				right: constant{3}}},
+		{"400..600", false, span{400, 600}},
+		{"-5..-1", false, span{-5, -1}},
+		{"a*0.5..1.5", false,
+			operation{
+				operator: "*",
+				left: reference{"a"},
+				right: span{0.5, 1.5}}},
 	}
 
 	for ix, d := range td {
@@ -127,3 +185,42 @@ func TestParser(t *testing.T) {
 		}
 	}
 }
+
+var benchmarkExpressions = []string{
+	"qps",
+	"qps / qps_per_replica",
+	"(a + b) * 3 - c / (d - 4.5) + 400..600",
+	"simultaneous_uploads / uploads_per_replica * 1.2 + (qps * 0.99 - lightweight_qps) * 5 * 1024 * 1024",
+}
+
+func BenchmarkParse(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		for _, s := range benchmarkExpressions {
+			if _, err := Parse(s); err != nil {
+				b.Fatalf("Failed to parse %s, %s", s, err)
+			}
+		}
+	}
+}
+
+func TestParseLeavesNoGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		for _, s := range append(benchmarkExpressions, "1+2)-3", "(1+2") {
+			Parse(s)
+		}
+	}
+	// Give any stray goroutines a chance to show up
+	time.Sleep(10 * time.Millisecond)
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Saw %d goroutines after parsing, %d before", after, before)
+	}
+}
+
+func BenchmarkTokenize(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		for _, s := range benchmarkExpressions {
+			tokenize(s)
+		}
+	}
+}
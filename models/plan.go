@@ -0,0 +1,63 @@
+// Evaluated plans, for serializing
+
+package models
+
+// The evaluated resources of a single model. RAM and CPU are per
+// replica, the totals across all replicas.
+type ModelPlan struct {
+	Name          string   `json:"name"`
+	Replicas      float64  `json:"replicas"`
+	RawReplicas   float64  `json:"raw_replicas"`
+	RAM           float64  `json:"ram"`
+	CPU           float64  `json:"cpu"`
+	TotalRAM      float64  `json:"total_ram"`
+	TotalCPU      float64  `json:"total_cpu"`
+	ReplicasRange Interval `json:"replicas_range"`
+	RAMRange      Interval `json:"ram_range"`
+	CPURange      Interval `json:"cpu_range"`
+	Cost          *Cost    `json:"cost,omitempty"`
+}
+
+// The evaluated resources of all models, sorted by name, and the
+// totals across all of them.
+type Plan struct {
+	Top      string      `json:"top"`
+	Models   []ModelPlan `json:"models"`
+	RAM      float64     `json:"total_ram"`
+	CPU      float64     `json:"total_cpu"`
+	RAMRange Interval    `json:"total_ram_range"`
+	CPURange Interval    `json:"total_cpu_range"`
+	Cost     *Cost       `json:"total_cost,omitempty"`
+}
+
+// Returns the plan for an evaluation that has been run, with monthly
+// costs if there is any pricing.
+func NewPlan(e *Evaluation, pricing *Pricing) Plan {
+	rv := Plan{Top: e.graph.top, Models: []ModelPlan{}}
+	for _, r := range e.Results() {
+		mp := ModelPlan{
+			Name:          r.Model,
+			Replicas:      r.Replicas,
+			RawReplicas:   r.RawReplicas,
+			RAM:           r.RAM,
+			CPU:           r.CPU,
+			TotalRAM:      r.TotalRAM(),
+			TotalCPU:      r.TotalCPU(),
+			ReplicasRange: r.ReplicasRange,
+			RAMRange:      r.RAMRange,
+			CPURange:      r.CPURange,
+		}
+		if pricing != nil {
+			c := pricing.ModelCost(e.models[r.Model])
+			mp.Cost = &c
+		}
+		rv.Models = append(rv.Models, mp)
+	}
+	rv.RAM, rv.CPU = e.Totals()
+	rv.RAMRange, rv.CPURange = TotalRanges(e.models)
+	if pricing != nil {
+		c := pricing.TotalCost(e.models)
+		rv.Cost = &c
+	}
+	return rv
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNewPlan(t *testing.T) {
+	models := graphModels()
+	models["back"].Resources["cpu"] = constant{2}
+	models["back"].Resources["ram"] = span{10, 20}
+	g, _ := NewGraph(models, "front")
+	e, err := g.Run(Scenario{Inputs: map[string]Expression{"qps": constant{100}}})
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+
+	p := NewPlan(e, nil)
+	if p.Top != "front" || len(p.Models) != 2 || p.Models[0].Name != "back" {
+		t.Fatalf("Unexpected plan %v", p)
+	}
+	back := p.Models[0]
+	if back.Replicas != 4 || back.TotalCPU != 8 || back.TotalRAM != 60 {
+		t.Errorf("Unexpected plan for back, %v", back)
+	}
+	if p.CPU != 8 || p.RAM != 60 || p.RAMRange != (Interval{40, 80}) {
+		t.Errorf("Unexpected totals, cpu %f, ram %f, range %s", p.CPU, p.RAM, p.RAMRange)
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	var seen Plan
+	if err := json.Unmarshal(data, &seen); err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if seen.Models[0] != back || seen.RAMRange != p.RAMRange {
+		t.Errorf("Saw %v after a round trip, expected %v", seen, p)
+	}
+}
+
+func TestNewPlanCost(t *testing.T) {
+	models := graphModels()
+	models["back"].Resources["cpu"] = constant{2}
+	g, _ := NewGraph(models, "front")
+	e, _ := g.Run(Scenario{Inputs: map[string]Expression{"qps": constant{100}}})
+
+	p := NewPlan(e, &Pricing{CPUCoreHour: 0.1, InstanceHour: 0.01})
+	back := p.Models[0]
+	// 4 replicas of 2 cores, and one of front without any cpu
+	if back.Cost == nil || back.Cost.CPU != 8*0.1*HoursPerMonth || back.Cost.Instances != 4*0.01*HoursPerMonth {
+		t.Errorf("Unexpected cost for back, %v", back.Cost)
+	}
+	if p.Cost == nil || p.Cost.Instances != 5*0.01*HoursPerMonth {
+		t.Errorf("Unexpected total cost, %v", p.Cost)
+	}
+	if NewPlan(e, nil).Cost != nil {
+		t.Errorf("Expected no cost without pricing")
+	}
+}
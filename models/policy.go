@@ -0,0 +1,162 @@
+// Redundancy and headroom policies for replica counts
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// A policy adjusting the replica count of a model. The replicas
+// expression is first divided by the target utilization, and rounded
+// up, then the redundancy ("n+k") adds k replicas, and finally the
+// count is raised to the minimum. Fields left at their zero value do
+// not change the count.
+type Policy struct {
+	Redundancy        string  `yaml:"redundancy,omitempty" json:"redundancy,omitempty"`
+	TargetUtilization float64 `yaml:"target_utilization,omitempty" json:"target_utilization,omitempty"`
+	MinReplicas       int     `yaml:"min_replicas,omitempty" json:"min_replicas,omitempty"`
+}
+
+// Returns true if the policy does not change any replica count
+func (p Policy) IsZero() bool {
+	return p == Policy{}
+}
+
+// Returns the number of extra replicas of the redundancy, k in "n+k"
+func (p Policy) extra() (int, error) {
+	r := strings.ToLower(strings.Replace(p.Redundancy, " ", "", -1))
+	if r == "" || r == "n" {
+		return 0, nil
+	}
+	if !strings.HasPrefix(r, "n+") {
+		return 0, errors.New(fmt.Sprintf("Bad redundancy %s, expected n+<k>", p.Redundancy))
+	}
+	k, err := strconv.Atoi(r[2:])
+	if err != nil || k < 0 {
+		return 0, errors.New(fmt.Sprintf("Bad redundancy %s, expected n+<k>", p.Redundancy))
+	}
+	return k, nil
+}
+
+// Checks that every part of the policy makes sense
+func (p Policy) Validate() error {
+	if _, err := p.extra(); err != nil {
+		return err
+	}
+	if p.TargetUtilization < 0 || p.TargetUtilization > 1 {
+		return errors.New(fmt.Sprintf("Bad target utilization %v, expected more than 0, at most 1", p.TargetUtilization))
+	}
+	if p.MinReplicas < 0 {
+		return errors.New(fmt.Sprintf("Bad minimum replicas %d", p.MinReplicas))
+	}
+	return nil
+}
+
+// Returns the policy with every field it leaves unset taken from a
+// default policy.
+func (p Policy) Merge(def Policy) Policy {
+	if p.Redundancy == "" {
+		p.Redundancy = def.Redundancy
+	}
+	if p.TargetUtilization == 0 {
+		p.TargetUtilization = def.TargetUtilization
+	}
+	if p.MinReplicas == 0 {
+		p.MinReplicas = def.MinReplicas
+	}
+	return p
+}
+
+// Returns the replica count for a value of the replicas expression.
+// The policy must be valid.
+func (p Policy) apply(v float64) float64 {
+	if p.TargetUtilization > 0 {
+		v = v / p.TargetUtilization
+	}
+	k, _ := p.extra()
+	v = math.Ceil(v) + float64(k)
+	return math.Max(v, float64(p.MinReplicas))
+}
+
+// Applies the policy without rounding the replica count up, so small
+// changes to the count are not lost.
+func (p Policy) applyUnrounded(v float64) float64 {
+	if p.TargetUtilization > 0 {
+		v = v / p.TargetUtilization
+	}
+	k, _ := p.extra()
+	return math.Max(v+float64(k), float64(p.MinReplicas))
+}
+
+// Returns a short description of the policy, like "n+2, target
+// utilization 0.6, min 3".
+func (p Policy) String() string {
+	parts := []string{}
+	if k, _ := p.extra(); k > 0 {
+		parts = append(parts, fmt.Sprintf("n+%d", k))
+	}
+	if p.TargetUtilization > 0 {
+		parts = append(parts, fmt.Sprintf("target utilization %s", formatNumber(p.TargetUtilization)))
+	}
+	if p.MinReplicas > 0 {
+		parts = append(parts, fmt.Sprintf("min %d", p.MinReplicas))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Returns the replica count of an evaluated model, before its policy
+// is applied.
+func rawReplicaCount(m *Model) float64 {
+	return math.Ceil(replicaExpr(m).Value(*m))
+}
+
+// Returns the replica count of an evaluated model, with its policy
+// applied.
+func replicaCount(m *Model) float64 {
+	return m.Policy.apply(replicaExpr(m).Value(*m))
+}
+
+// Returns the range of the replica count of an evaluated model, with
+// its policy applied.
+func replicaRange(m *Model) Interval {
+	r := replicaExpr(m).Range(*m)
+	return Interval{m.Policy.apply(r.Min), m.Policy.apply(r.Max)}
+}
+
+// Parses a policy file, holding a single policy, and checks it.
+func ParsePolicy(data []byte) (Policy, error) {
+	rv := Policy{}
+	if err := yaml.UnmarshalStrict(data, &rv); err != nil {
+		return rv, errors.New(fmt.Sprintf("Bad policy, %s", err))
+	}
+	return rv, rv.Validate()
+}
+
+// Loads a policy file, see ParsePolicy.
+func LoadPolicy(filename string) (Policy, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return Policy{}, err
+	}
+	return ParsePolicy(data)
+}
+
+// Returns a copy of the graph with a default policy, which applies to
+// every model that does not set the same part of the policy itself.
+func (g *Graph) WithPolicy(def Policy) (*Graph, error) {
+	if err := def.Validate(); err != nil {
+		return nil, err
+	}
+	models := CloneModels(g.models)
+	for _, m := range models {
+		m.Policy = m.Policy.Merge(def)
+	}
+	return NewGraph(models, g.top)
+}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPolicyApply(t *testing.T) {
+	td := []struct {
+		p        Policy
+		v        float64
+		expected float64
+	}{
+		{Policy{}, 2.1, 3},
+		{Policy{Redundancy: "n"}, 2.1, 3},
+		{Policy{Redundancy: "n+2"}, 2.1, 5},
+		{Policy{Redundancy: "N + 1"}, 4, 5},
+		{Policy{TargetUtilization: 0.6}, 3, 5},
+		{Policy{Redundancy: "n+1", TargetUtilization: 0.5}, 3, 7},
+		{Policy{MinReplicas: 3}, 1, 3},
+		{Policy{MinReplicas: 3}, 4.5, 5},
+		{Policy{Redundancy: "n+2", TargetUtilization: 0.6, MinReplicas: 3}, 0, 3},
+	}
+	for ix, d := range td {
+		if err := d.p.Validate(); err != nil {
+			t.Errorf("test %d, unexpected error, %s", ix, err)
+		}
+		if seen := d.p.apply(d.v); seen != d.expected {
+			t.Errorf("test %d, saw %v, expected %v", ix, seen, d.expected)
+		}
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	for ix, p := range []Policy{
+		{Redundancy: "n+x"},
+		{Redundancy: "2"},
+		{Redundancy: "n+-1"},
+		{TargetUtilization: 1.5},
+		{TargetUtilization: -0.5},
+		{MinReplicas: -1},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("test %d, expected an error for %v", ix, p)
+		}
+	}
+}
+
+func TestPolicyMergeAndString(t *testing.T) {
+	p := Policy{Redundancy: "n+1"}.Merge(Policy{Redundancy: "n+2", TargetUtilization: 0.6, MinReplicas: 3})
+	if p != (Policy{Redundancy: "n+1", TargetUtilization: 0.6, MinReplicas: 3}) {
+		t.Errorf("Saw merged policy %v", p)
+	}
+	if s := p.String(); s != "n+1, target utilization 0.6, min 3" {
+		t.Errorf("Saw %q", s)
+	}
+	if !(Policy{}).IsZero() || p.IsZero() {
+		t.Errorf("Unexpected IsZero")
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy([]byte("redundancy: n+1\ntarget_utilization: 0.7\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if p != (Policy{Redundancy: "n+1", TargetUtilization: 0.7}) {
+		t.Errorf("Unexpected policy %v", p)
+	}
+	for _, data := range []string{"redundancy: n+1\nmin: 3\n", "redundancy: 2n\n"} {
+		if _, err := ParsePolicy([]byte(data)); err == nil {
+			t.Errorf("Expected an error for %q", data)
+		}
+	}
+}
+
+const policyModels = `- name: front
+  inputs:
+   - qps
+  resources:
+   replicas: qps / 100
+  policy:
+   redundancy: n+2
+   target_utilization: 0.5
+  outputs:
+   - backend: back
+     input: qps
+     expression: qps
+- name: back
+  inputs:
+   - qps
+  resources:
+   replicas: qps / 1000
+   ram: 1024
+`
+
+func TestPolicyResults(t *testing.T) {
+	models := loadModels(t, policyModels)
+	g, err := NewGraph(models, "front")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	s := Scenario{Inputs: map[string]Expression{"qps": constant{250}}}
+	e, err := g.Run(s)
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	front, _ := e.Result("front")
+	if front.RawReplicas != 3 || front.Replicas != 7 {
+		t.Errorf("Saw front replicas %v, raw %v, expected 7, raw 3", front.Replicas, front.RawReplicas)
+	}
+	back, _ := e.Result("back")
+	if back.RawReplicas != 1 || back.Replicas != 1 {
+		t.Errorf("Saw back replicas %v, raw %v, expected 1, raw 1", back.Replicas, back.RawReplicas)
+	}
+
+	var buf bytes.Buffer
+	PrintModel(&buf, e.Models()["front"], nil)
+	if !strings.Contains(buf.String(), "replicas: 7 # 3 before policy n+2, target utilization 0.5") {
+		t.Errorf("Expected the raw replicas in the printed model, saw\n%s", buf.String())
+	}
+
+	// A global policy fills in what the models leave unset
+	global, err := g.WithPolicy(Policy{Redundancy: "n+1", MinReplicas: 3})
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	e, err = global.Run(s)
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if r, _ := e.Result("front"); r.Replicas != 7 {
+		t.Errorf("Saw front replicas %v, expected 7", r.Replicas)
+	}
+	if r, _ := e.Result("back"); r.Replicas != 3 || r.RAM != 1024 {
+		t.Errorf("Saw back %v, expected 3 replicas", r)
+	}
+	if m, _ := g.Model("back"); m.Policy != (Policy{}) {
+		t.Errorf("Expected the original graph to be unchanged")
+	}
+
+	if _, err := g.WithPolicy(Policy{TargetUtilization: 2}); err == nil {
+		t.Errorf("Expected an error for a bad global policy")
+	}
+	models["back"].Policy = Policy{Redundancy: "n+x"}
+	if _, err := NewGraph(models, "front"); err == nil {
+		t.Errorf("Expected an error for a bad model policy")
+	}
+}
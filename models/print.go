@@ -0,0 +1,122 @@
+// Printing expressions
+
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Formats a number as compactly as possible, without exponents.
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// Renders an expression, using ref to render every reference. An
+// operation is parenthesised only when needed, subtraction and
+// division being left-associative.
+func render(e Expression, ref func(string) string) string {
+	switch x := e.(type) {
+	case constant:
+		return formatNumber(x.value)
+	case span:
+		return formatNumber(x.min) + ".." + formatNumber(x.max)
+	case reference:
+		return ref(x.name)
+	case variable:
+		return ref(x.name)
+	case distribution:
+		params := []string{}
+		for _, p := range x.params {
+			params = append(params, formatNumber(p))
+		}
+		return fmt.Sprintf("%s(%s)", x.kind, strings.Join(params, ", "))
+	case operation:
+		left := render(x.left, ref)
+		right := render(x.right, ref)
+		if l, ok := x.left.(operation); ok && precedence[l.operator] < precedence[x.operator] {
+			left = "(" + left + ")"
+		}
+		if r, ok := x.right.(operation); ok {
+			rp := precedence[r.operator]
+			xp := precedence[x.operator]
+			if rp < xp || (rp == xp && (x.operator == "-" || x.operator == "/")) {
+				right = "(" + right + ")"
+			}
+		}
+		return fmt.Sprintf("%s %s %s", left, x.operator, right)
+	}
+	return fmt.Sprintf("%v", e)
+}
+
+// Returns the name of the referenced input or variable
+func identity(name string) string {
+	return name
+}
+
+func (c constant) String() string {
+	return render(c, identity)
+}
+
+func (s span) String() string {
+	return render(s, identity)
+}
+
+func (r reference) String() string {
+	return render(r, identity)
+}
+
+// A variable prints as its name, as that is how it is referred to in
+// expressions.
+func (v variable) String() string {
+	return render(v, identity)
+}
+
+func (d distribution) String() string {
+	return render(d, identity)
+}
+
+func (o operation) String() string {
+	return render(o, identity)
+}
+
+// Returns the keys of a map of expressions, sorted
+func sortedExpressionNames(exprs map[string]Expression) []string {
+	rv := []string{}
+	for name, _ := range exprs {
+		rv = append(rv, name)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// Returns a one-line summary of a model definition, for errors and
+// debug output.
+func (m *Model) String() string {
+	inputs := []string{}
+	for name, _ := range m.Inputs {
+		inputs = append(inputs, name)
+	}
+	sort.Strings(inputs)
+	variables := []string{}
+	for _, name := range sortedVariableNames(m) {
+		variables = append(variables, fmt.Sprintf("%s = %s", name, m.Variables[name].expr))
+	}
+	outputs := []string{}
+	for _, o := range m.Outputs {
+		outputs = append(outputs, fmt.Sprintf("%s.%s = %s", o.backend, o.input, o.value))
+	}
+	resources := []string{}
+	for _, name := range sortedExpressionNames(m.Resources) {
+		resources = append(resources, fmt.Sprintf("%s = %s", name, m.Resources[name]))
+	}
+
+	return fmt.Sprintf("%s{inputs: [%s], variables: [%s], outputs: [%s], resources: [%s]}",
+		m.Name,
+		strings.Join(inputs, ", "),
+		strings.Join(variables, ", "),
+		strings.Join(outputs, ", "),
+		strings.Join(resources, ", "))
+}
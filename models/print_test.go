@@ -0,0 +1,83 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	td := []struct {
+		e        Expression
+		expected string
+	}{
+		{constant{-3}, "-3"},
+		{constant{0.75}, "0.75"},
+		{reference{"qps"}, "qps"},
+		{newVariable("v", constant{2}), "v"},
+		{span{-1, 2.5}, "-1..2.5"},
+		{distribution{"triangular", []float64{1, 2, 3}}, "triangular(1, 2, 3)"},
+		{operation{"-", reference{"a"}, constant{-3}}, "a - -3"},
+		{operation{"/", operation{"/", reference{"a"}, reference{"b"}}, reference{"c"}}, "a / b / c"},
+	}
+
+	for ix, d := range td {
+		if seen := d.e.String(); seen != d.expected {
+			t.Errorf("test %d, saw %q, expected %q", ix, seen, d.expected)
+		}
+		if seen := fmt.Sprintf("%v", d.e); seen != d.expected {
+			t.Errorf("test %d, %%v saw %q, expected %q", ix, seen, d.expected)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	td := []string{
+		"1 + 2 * 3",
+		"(1 + 2) * 3",
+		"qps - lightweight_qps",
+		"a - b - c",
+		"a - (b - c)",
+		"a / (b * c)",
+		"a / b * c",
+		"5 * 1024 * 1024",
+		"qps * 0.99",
+		"qps * -0.5 + 3",
+		"400..600 * x",
+		"normal(500, 50)",
+		"(a + b) / (c - d)",
+	}
+
+	for ix, s := range td {
+		first, err := Parse(s)
+		if err != nil {
+			t.Errorf("test %d, failed to parse %q, %s", ix, s, err)
+			continue
+		}
+		printed := first.String()
+		if printed != s {
+			t.Errorf("test %d, %q printed as %q", ix, s, printed)
+		}
+		second, err := Parse(printed)
+		if err != nil {
+			t.Errorf("test %d, failed to re-parse %q, %s", ix, printed, err)
+			continue
+		}
+		if !compareExpr(first, second) {
+			t.Errorf("test %d, %q did not round-trip, %v and %v", ix, s, first, second)
+		}
+	}
+}
+
+func TestModelString(t *testing.T) {
+	m := New("test")
+	m.NewInput("qps")
+	m.NewOutput("backend", "qps", operation{"*", reference{"qps"}, constant{2}})
+	m.Variables["per"] = newVariable("per", constant{100})
+	m.Resources["replicas"] = operation{"/", reference{"qps"}, reference{"per"}}
+	m.Resources["cpu"] = constant{1}
+
+	expected := "test{inputs: [qps], variables: [per = 100], outputs: [backend.qps = qps * 2], resources: [cpu = 1, replicas = qps / per]}"
+	if seen := m.String(); seen != expected {
+		t.Errorf("Saw %q, expected %q", seen, expected)
+	}
+}
@@ -0,0 +1,207 @@
+// Sizing for the peak of inputs that vary over the day or week
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+var weekdays = []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+// Traffic profiles for top-level inputs. Every profile is a list of
+// multipliers of the value of the input, one per time slot, and all
+// profiles have the same number of slots. Slots optionally names the
+// slots; 24 slots are taken to be hours, and 168 the hours of a week.
+type Profiles struct {
+	Slots  []string             `yaml:"slots"`
+	Inputs map[string][]float64 `yaml:"inputs"`
+}
+
+// The peak and average of a value over all slots, and the first slot
+// with the peak.
+type SlotStats struct {
+	Peak     float64
+	Average  float64
+	PeakSlot string
+}
+
+// The replicas, and the RAM and CPU across all replicas, of a model
+// over all slots.
+type ProfileResult struct {
+	Model    string
+	Replicas SlotStats
+	RAM      SlotStats
+	CPU      SlotStats
+}
+
+// The results of every model over all slots, sorted by name. RAM and
+// CPU are the totals of all models in each slot, and PeakRAM and
+// PeakCPU the sums of the peaks of every model, which is what sizing
+// every model for its own peak needs.
+type ProfileReport struct {
+	Slots   []string
+	Models  []ProfileResult
+	RAM     SlotStats
+	CPU     SlotStats
+	PeakRAM float64
+	PeakCPU float64
+}
+
+// Parses a profiles file, see Validate.
+func ParseProfiles(data []byte) (Profiles, error) {
+	rv := Profiles{}
+	if err := yaml.UnmarshalStrict(data, &rv); err != nil {
+		return rv, errors.New(fmt.Sprintf("Bad profiles, %s", err))
+	}
+	return rv, rv.Validate()
+}
+
+// Checks that there is at least one profile, that every profile has
+// the same number of slots, and as many as there are slot names, if
+// any, and that no multiplier is negative.
+func (p Profiles) Validate() error {
+	if len(p.Inputs) == 0 {
+		return errors.New("Bad profiles, no inputs")
+	}
+	names := []string{}
+	for name, _ := range p.Inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	slots := len(p.Slots)
+	for _, name := range names {
+		profile := p.Inputs[name]
+		if slots == 0 {
+			slots = len(profile)
+		}
+		if len(profile) == 0 || len(profile) != slots {
+			return errors.New(fmt.Sprintf("Profile of %s has %d slots, expected %d", name, len(profile), slots))
+		}
+		for _, v := range profile {
+			if v < 0 {
+				return errors.New(fmt.Sprintf("Profile of %s has a negative multiplier", name))
+			}
+		}
+	}
+	return nil
+}
+
+// Loads a profiles file, see ParseProfiles.
+func LoadProfiles(filename string) (Profiles, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return Profiles{}, err
+	}
+	return ParseProfiles(data)
+}
+
+// Returns the names of all slots
+func (p Profiles) slotNames() []string {
+	if len(p.Slots) > 0 {
+		return p.Slots
+	}
+	slots := 0
+	for _, profile := range p.Inputs {
+		slots = len(profile)
+	}
+	rv := []string{}
+	for ix := 0; ix < slots; ix++ {
+		switch slots {
+		case 24:
+			rv = append(rv, fmt.Sprintf("%02d:00", ix))
+		case 24 * 7:
+			rv = append(rv, fmt.Sprintf("%s %02d:00", weekdays[ix/24], ix%24))
+		default:
+			rv = append(rv, fmt.Sprintf("%d", ix))
+		}
+	}
+	return rv
+}
+
+// Accumulates the peak and average of a value, slot by slot
+func (s *SlotStats) add(slot string, v float64, slots int) {
+	if s.PeakSlot == "" || v > s.Peak {
+		s.Peak = v
+		s.PeakSlot = slot
+	}
+	s.Average += v / float64(slots)
+}
+
+// Evaluates the graph once per slot, with every top-level input that
+// has a profile multiplied by its multiplier for the slot, and every
+// other input as it is. Fails if the profiles are not valid.
+func (p Profiles) Run(g *Graph, start map[string]Expression) (ProfileReport, error) {
+	if err := p.Validate(); err != nil {
+		return ProfileReport{}, err
+	}
+	rv := ProfileReport{Slots: p.slotNames()}
+	for name, _ := range p.Inputs {
+		if _, ok := start[name]; !ok {
+			return rv, errors.New(fmt.Sprintf("Input %s has a profile, but no value", name))
+		}
+	}
+
+	results := make(map[string]*ProfileResult)
+	for ix, slot := range rv.Slots {
+		inputs := make(map[string]Expression)
+		for name, e := range start {
+			inputs[name] = e
+			if profile, ok := p.Inputs[name]; ok {
+				inputs[name] = operation{"*", e, constant{profile[ix]}}
+			}
+		}
+		e, err := g.Run(Scenario{Inputs: inputs})
+		if err != nil {
+			return rv, errors.New(fmt.Sprintf("Failed to evaluate slot %s, %s", slot, err))
+		}
+		for _, r := range e.Results() {
+			pr, ok := results[r.Model]
+			if !ok {
+				pr = &ProfileResult{Model: r.Model}
+				results[r.Model] = pr
+			}
+			pr.Replicas.add(slot, r.Replicas, len(rv.Slots))
+			pr.RAM.add(slot, r.TotalRAM(), len(rv.Slots))
+			pr.CPU.add(slot, r.TotalCPU(), len(rv.Slots))
+		}
+		ram, cpu := e.Totals()
+		rv.RAM.add(slot, ram, len(rv.Slots))
+		rv.CPU.add(slot, cpu, len(rv.Slots))
+	}
+
+	for _, name := range g.Names() {
+		pr := results[name]
+		rv.Models = append(rv.Models, *pr)
+		rv.PeakRAM += pr.RAM.Peak
+		rv.PeakCPU += pr.CPU.Peak
+	}
+	return rv, nil
+}
+
+func printSlotStats(w io.Writer, indent, name string, s SlotStats) {
+	fmt.Fprintf(w, "%s%s: %f # peak at %s, average %f\n", indent, name, s.Peak, s.PeakSlot, s.Average)
+}
+
+// Prints the peak of every model, when it peaks, and its average, and
+// the same for the totals.
+func PrintProfile(w io.Writer, r ProfileReport) {
+	fmt.Fprintf(w, "# %d slots, sized for the peak\n", len(r.Slots))
+	for _, m := range r.Models {
+		fmt.Fprintf(w, "- name: %s\n", m.Model)
+		fmt.Fprintf(w, "  replicas: %.0f # peak at %s, average %.1f\n", m.Replicas.Peak, m.Replicas.PeakSlot, m.Replicas.Average)
+		printSlotStats(w, "  ", "ram", m.RAM)
+		printSlotStats(w, "  ", "cpu", m.CPU)
+	}
+	fmt.Fprintf(w, "\ntotals: # every model at its own peak\n")
+	fmt.Fprintf(w, " ram: %f\n", r.PeakRAM)
+	fmt.Fprintf(w, " cpu: %f\n", r.PeakCPU)
+	fmt.Fprintf(w, "\nconcurrent: # all models in the same slot\n")
+	printSlotStats(w, " ", "ram", r.RAM)
+	printSlotStats(w, " ", "cpu", r.CPU)
+}
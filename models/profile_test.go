@@ -0,0 +1,113 @@
+package models
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseProfiles(t *testing.T) {
+	p, err := ParseProfiles([]byte("inputs:\n  qps: [1, 2, 0.5]\n  jobs: [0, 1, 0]\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if names := p.slotNames(); len(names) != 3 || names[2] != "2" {
+		t.Errorf("Unexpected slots %v", names)
+	}
+	for _, data := range []string{
+		"inputs: {}\n",
+		"inputs:\n  qps: [1, 2]\n  jobs: [1]\n",
+		"slots: [day, night]\ninputs:\n  qps: [1, 2, 3]\n",
+		"inputs:\n  qps: [1, -1]\n",
+		"inputs:\n  qps: []\n",
+		"input:\n  qps: [1]\n",
+	} {
+		if _, err := ParseProfiles([]byte(data)); err == nil {
+			t.Errorf("Expected an error for %q", data)
+		}
+	}
+}
+
+func TestProfileSlotNames(t *testing.T) {
+	hourly := Profiles{Inputs: map[string][]float64{"qps": make([]float64, 24)}}
+	if names := hourly.slotNames(); names[13] != "13:00" {
+		t.Errorf("Saw %s, expected 13:00", names[13])
+	}
+	weekly := Profiles{Inputs: map[string][]float64{"qps": make([]float64, 168)}}
+	if names := weekly.slotNames(); names[24+9] != "Tue 09:00" {
+		t.Errorf("Saw %s, expected Tue 09:00", names[24+9])
+	}
+}
+
+// Two backends, one taking interactive traffic and one batch jobs
+func profileModels() map[string]*Model {
+	front := New("front")
+	front.NewInput("qps")
+	front.NewInput("jobs")
+	front.NewOutput("web", "qps", reference{"qps"})
+	front.NewOutput("batch", "jobs", reference{"jobs"})
+	web := New("web")
+	web.NewInput("qps")
+	web.Resources["replicas"] = operation{"/", reference{"qps"}, constant{100}}
+	web.Resources["cpu"] = constant{2}
+	batch := New("batch")
+	batch.NewInput("jobs")
+	batch.Resources["replicas"] = operation{"/", reference{"jobs"}, constant{10}}
+	batch.Resources["cpu"] = constant{4}
+	return map[string]*Model{"front": front, "web": web, "batch": batch}
+}
+
+func TestProfileRun(t *testing.T) {
+	g, err := NewGraph(profileModels(), "front")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	p := Profiles{
+		Slots: []string{"night", "day", "evening"},
+		Inputs: map[string][]float64{
+			"qps":  {0.5, 1, 2},
+			"jobs": {2, 1, 0.5},
+		},
+	}
+	r, err := p.Run(g, map[string]Expression{"qps": constant{1000}, "jobs": constant{100}})
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if len(r.Models) != 3 || r.Models[0].Model != "batch" || r.Models[2].Model != "web" {
+		t.Fatalf("Unexpected models %v", r.Models)
+	}
+	batch, web := r.Models[0], r.Models[2]
+	if batch.Replicas.Peak != 20 || batch.Replicas.PeakSlot != "night" || math.Abs(batch.Replicas.Average-35.0/3) > 1e-9 {
+		t.Errorf("Unexpected batch replicas %v", batch.Replicas)
+	}
+	if web.Replicas.Peak != 20 || web.Replicas.PeakSlot != "evening" || web.CPU.Peak != 40 {
+		t.Errorf("Unexpected web %v", web)
+	}
+	// Each at its own peak needs 80+40 cores, but at most 90 at once
+	if r.PeakCPU != 120 || r.CPU.Peak != 90 || r.CPU.PeakSlot != "night" {
+		t.Errorf("Saw peak cpu %v, concurrent %v", r.PeakCPU, r.CPU)
+	}
+
+	var buf bytes.Buffer
+	PrintProfile(&buf, r)
+	if !strings.Contains(buf.String(), "- name: web\n  replicas: 20 # peak at evening, average 11.7\n") {
+		t.Errorf("Unexpected profile report\n%s", buf.String())
+	}
+
+	if _, err := p.Run(g, map[string]Expression{"qps": constant{1000}}); err == nil {
+		t.Errorf("Expected an error for a profile without a value")
+	}
+
+	// Profiles built in code are checked too, rather than panicking
+	for ix, bad := range []Profiles{
+		{},
+		{Slots: []string{"a", "b"}, Inputs: map[string][]float64{"qps": {1}}},
+		{Inputs: map[string][]float64{"qps": {}}},
+		{Inputs: map[string][]float64{"qps": {1, 2}, "jobs": {1}}},
+	} {
+		if _, err := bad.Run(g, map[string]Expression{"qps": constant{1000}, "jobs": constant{100}}); err == nil {
+			t.Errorf("test %d, expected an error for bad profiles", ix)
+		}
+	}
+}
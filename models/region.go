@@ -0,0 +1,211 @@
+// Planning across regions, and for the loss of any one region
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// A region the models are deployed to, and the share of the top-level
+// traffic it normally gets.
+type Region struct {
+	Name  string  `yaml:"name"`
+	Share float64 `yaml:"share"`
+}
+
+// The regions to plan, and the top-level inputs that are traffic, to
+// be split between the regions. Inputs that are not traffic, like
+// sizes or ratios, are the same in every region. If Traffic is empty,
+// every top-level input is traffic.
+type RegionSpec struct {
+	Traffic []string `yaml:"traffic"`
+	Regions []Region `yaml:"regions"`
+}
+
+// The replicas and resources of a model in one region, normally and
+// when it has to take over the traffic of a lost region. The failover
+// figures are for the loss that needs the most replicas, which is
+// named by WorstLoss.
+type RegionResult struct {
+	Model       string
+	Replicas    float64
+	RAM         float64
+	CPU         float64
+	Failover    float64
+	FailoverRAM float64
+	FailoverCPU float64
+	WorstLoss   string
+}
+
+// The plan for one region. Share is the normal share of the traffic,
+// and WorstShare the largest share after losing another region. The
+// totals are across all replicas of all models in the region.
+type RegionPlan struct {
+	Region      string
+	Share       float64
+	WorstShare  float64
+	Models      []RegionResult
+	RAM         float64
+	CPU         float64
+	FailoverRAM float64
+	FailoverCPU float64
+}
+
+// Parses a regions file. Every region needs a unique name and a
+// positive share. Shares are relative, and are scaled to add up to 1.
+func ParseRegions(data []byte) (RegionSpec, error) {
+	rv := RegionSpec{}
+	if err := yaml.UnmarshalStrict(data, &rv); err != nil {
+		return rv, errors.New(fmt.Sprintf("Bad regions, %s", err))
+	}
+	if len(rv.Regions) == 0 {
+		return rv, errors.New("No regions defined")
+	}
+	seen := make(map[string]bool)
+	sum := 0.0
+	for _, r := range rv.Regions {
+		if seen[r.Name] {
+			return rv, errors.New(fmt.Sprintf("Region %s defined more than once", r.Name))
+		}
+		if r.Share <= 0 {
+			return rv, errors.New(fmt.Sprintf("Region %s needs a positive share", r.Name))
+		}
+		seen[r.Name] = true
+		sum += r.Share
+	}
+	for ix := range rv.Regions {
+		rv.Regions[ix].Share /= sum
+	}
+	return rv, nil
+}
+
+// Loads a regions file, see ParseRegions.
+func LoadRegions(filename string) (RegionSpec, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return RegionSpec{}, err
+	}
+	return ParseRegions(data)
+}
+
+// Evaluates the graph with a share of the traffic inputs, and every
+// other input as it is.
+func (s RegionSpec) evaluateShare(g *Graph, inputs map[string]Expression, share float64) (map[string]*Model, error) {
+	scaled := make(map[string]Expression)
+	for name, e := range inputs {
+		scaled[name] = e
+		if s.isTraffic(name) {
+			scaled[name] = operation{"*", e, constant{share}}
+		}
+	}
+	return g.Evaluate(scaled)
+}
+
+// Returns true if a top-level input is split between the regions
+func (s RegionSpec) isTraffic(name string) bool {
+	if len(s.Traffic) == 0 {
+		return true
+	}
+	for _, t := range s.Traffic {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Plans every region, with its share of the top-level inputs, and for
+// the loss of any single region, whose traffic then goes to the
+// surviving regions in proportion to their shares. Needs at least two
+// regions, and a value for every traffic input.
+func (s RegionSpec) Plan(g *Graph, inputs map[string]Expression) ([]RegionPlan, error) {
+	regions := s.Regions
+	if len(regions) < 2 {
+		return nil, errors.New("Need at least two regions to plan for losing one")
+	}
+	for _, name := range s.Traffic {
+		if _, ok := inputs[name]; !ok {
+			return nil, errors.New(fmt.Sprintf("Traffic input %s has no value", name))
+		}
+	}
+	rv := []RegionPlan{}
+	for _, region := range regions {
+		usage, err := s.evaluateShare(g, inputs, region.Share)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to evaluate region %s, %s", region.Name, err))
+		}
+		plan := RegionPlan{Region: region.Name, Share: region.Share, WorstShare: region.Share}
+		results := make(map[string]int)
+		for _, name := range sortedModelNames(usage) {
+			m := usage[name]
+			r := RegionResult{Model: name, Replicas: replicaCount(m), RAM: allRAM(m), CPU: allCPU(m)}
+			r.Failover, r.FailoverRAM, r.FailoverCPU = r.Replicas, r.RAM, r.CPU
+			results[name] = len(plan.Models)
+			plan.Models = append(plan.Models, r)
+		}
+
+		for _, lost := range regions {
+			if lost.Name == region.Name {
+				continue
+			}
+			share := region.Share / (1 - lost.Share)
+			if share > plan.WorstShare {
+				plan.WorstShare = share
+			}
+			usage, err := s.evaluateShare(g, inputs, share)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Failed to evaluate region %s after losing %s, %s", region.Name, lost.Name, err))
+			}
+			for name, m := range usage {
+				r := &plan.Models[results[name]]
+				if replicas := replicaCount(m); replicas > r.Failover {
+					r.Failover = replicas
+					r.FailoverRAM = allRAM(m)
+					r.FailoverCPU = allCPU(m)
+					r.WorstLoss = lost.Name
+				}
+			}
+		}
+
+		for _, r := range plan.Models {
+			plan.RAM += r.RAM
+			plan.CPU += r.CPU
+			plan.FailoverRAM += r.FailoverRAM
+			plan.FailoverCPU += r.FailoverCPU
+		}
+		rv = append(rv, plan)
+	}
+	return rv, nil
+}
+
+// Prints the plan for every region, with the replicas needed normally
+// and to survive the loss of any other region, and the totals across
+// all regions.
+func PrintRegions(w io.Writer, plans []RegionPlan) {
+	fmt.Fprintf(w, "\nregions: # replicas normally, and to survive losing any one region\n")
+	var ram, cpu, failoverRAM, failoverCPU float64
+	for _, p := range plans {
+		fmt.Fprintf(w, " %s: # %.0f%% of traffic, up to %.0f%% after a loss\n", p.Region, 100*p.Share, 100*p.WorstShare)
+		for _, r := range p.Models {
+			if r.WorstLoss == "" {
+				fmt.Fprintf(w, "  %s: %.0f\n", r.Model, r.Replicas)
+				continue
+			}
+			fmt.Fprintf(w, "  %s: %.0f # %.0f normally, most after losing %s\n", r.Model, r.Failover, r.Replicas, r.WorstLoss)
+		}
+		fmt.Fprintf(w, "  ram: %f # %f normally\n", p.FailoverRAM, p.RAM)
+		fmt.Fprintf(w, "  cpu: %f # %f normally\n", p.FailoverCPU, p.CPU)
+		ram += p.RAM
+		cpu += p.CPU
+		failoverRAM += p.FailoverRAM
+		failoverCPU += p.FailoverCPU
+	}
+	fmt.Fprintf(w, " total:\n")
+	fmt.Fprintf(w, "  ram: %f # %f normally\n", failoverRAM, ram)
+	fmt.Fprintf(w, "  cpu: %f # %f normally\n", failoverCPU, cpu)
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseRegions(t *testing.T) {
+	spec, err := ParseRegions([]byte("traffic: [qps]\nregions:\n- name: east\n  share: 3\n- name: west\n  share: 1\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	regions := spec.Regions
+	if len(spec.Traffic) != 1 || spec.Traffic[0] != "qps" {
+		t.Errorf("Unexpected traffic %v", spec.Traffic)
+	}
+	if len(regions) != 2 || regions[0] != (Region{"east", 0.75}) || regions[1] != (Region{"west", 0.25}) {
+		t.Errorf("Unexpected regions %v", regions)
+	}
+	for _, data := range []string{
+		"",
+		"- name: east\n  share: 1\n",
+		"regions:\n- name: east\n  share: 1\n- name: east\n  share: 1\n",
+		"regions:\n- name: east\n  share: 0\n",
+		"regions:\n- name: east\n  weight: 1\n",
+	} {
+		if _, err := ParseRegions([]byte(data)); err == nil {
+			t.Errorf("Expected an error for %q", data)
+		}
+	}
+}
+
+func TestPlanRegions(t *testing.T) {
+	g, err := NewGraph(graphModels(), "front")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	inputs := map[string]Expression{"qps": constant{1000}}
+	spec := RegionSpec{Regions: []Region{{"a", 0.5}, {"b", 0.3}, {"c", 0.2}}}
+
+	plans, err := spec.Plan(g, inputs)
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if len(plans) != 3 {
+		t.Fatalf("Saw %d region plans, expected 3", len(plans))
+	}
+	// back needs qps*2*2/100 replicas, so 40 for all the traffic
+	td := []struct {
+		region     string
+		worstShare float64
+		replicas   float64
+		failover   float64
+		worstLoss  string
+	}{
+		{"a", 0.5 / 0.7, 20, 29, "b"},
+		{"b", 0.3 / 0.5, 12, 24, "a"},
+		{"c", 0.2 / 0.5, 8, 16, "a"},
+	}
+	for ix, d := range td {
+		p := plans[ix]
+		if p.Region != d.region || math.Abs(p.WorstShare-d.worstShare) > 1e-9 {
+			t.Errorf("test %d, saw region %s worst share %f, expected %s, %f", ix, p.Region, p.WorstShare, d.region, d.worstShare)
+		}
+		if len(p.Models) != 2 || p.Models[0].Model != "back" {
+			t.Fatalf("test %d, unexpected models %v", ix, p.Models)
+		}
+		back := p.Models[0]
+		if back.Replicas != d.replicas || back.Failover != d.failover || back.WorstLoss != d.worstLoss {
+			t.Errorf("test %d, saw %v, expected %v, %v after losing %s", ix, back, d.replicas, d.failover, d.worstLoss)
+		}
+	}
+
+	var buf bytes.Buffer
+	PrintRegions(&buf, plans)
+	if !strings.Contains(buf.String(), " a: # 50% of traffic, up to 71% after a loss\n  back: 29 # 20 normally, most after losing b\n") {
+		t.Errorf("Unexpected region report\n%s", buf.String())
+	}
+
+	if _, err := (RegionSpec{Regions: []Region{{"a", 1}}}).Plan(g, inputs); err == nil {
+		t.Errorf("Expected an error for a single region")
+	}
+}
+
+func TestPlanRegionsTraffic(t *testing.T) {
+	g, err := NewGraph(profileModels(), "front")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	inputs := map[string]Expression{"qps": constant{1000}, "jobs": constant{100}}
+	spec := RegionSpec{Traffic: []string{"qps"}, Regions: []Region{{"a", 0.5}, {"b", 0.5}}}
+	plans, err := spec.Plan(g, inputs)
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	// Only qps is split, every region runs all the jobs
+	batch, web := plans[0].Models[0], plans[0].Models[2]
+	if batch.Model != "batch" || batch.Replicas != 10 || batch.Failover != 10 {
+		t.Errorf("Saw %v, expected 10 replicas of batch", batch)
+	}
+	if web.Model != "web" || web.Replicas != 5 || web.Failover != 10 {
+		t.Errorf("Saw %v, expected 5 replicas of web, 10 after a loss", web)
+	}
+
+	spec.Traffic = []string{"users"}
+	if _, err := spec.Plan(g, inputs); err == nil {
+		t.Errorf("Expected an error for a traffic input without a value")
+	}
+}
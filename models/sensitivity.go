@@ -0,0 +1,176 @@
+// Sensitivity analysis, how much do the totals move when a single
+// assumption changes?
+
+package models
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// The relative size of the perturbation applied to each value.
+const perturbation = 0.01
+
+// How total CPU and RAM respond to a change in a single variable or
+// top-level input. The changes are measured before replica counts are
+// rounded up, as rounding hides any change that does not happen to
+// need another replica.
+type Sensitivity struct {
+	Model string
+	Name  string
+	Input bool
+	Value float64
+
+	// Change in totals per unit change of the value
+	CPUPerUnit float64
+	RAMPerUnit float64
+	// Change in totals per 1% change of the value. There is no 1%
+	// change of a value of 0, so these are 0 and NoPercent is set.
+	CPUPerPercent float64
+	RAMPerPercent float64
+	NoPercent     bool
+	// Change in the rounded totals, for the 1% change, or for a whole
+	// unit if the value is 0
+	CPURounded float64
+	RAMRounded float64
+}
+
+// The total RAM and CPU of an evaluation, with and without rounding
+// up replica counts.
+type sensitivityTotals struct {
+	ram, cpu               float64
+	roundedRAM, roundedCPU float64
+}
+
+// Returns the totals of an evaluation
+func evaluationTotals(e *Evaluation) sensitivityTotals {
+	rv := sensitivityTotals{}
+	rv.roundedRAM, rv.roundedCPU = e.Totals()
+	for _, m := range e.Models() {
+		replicas := m.Policy.applyUnrounded(replicaExpr(m).Value(*m))
+		if ram, ok := m.Resources["ram"]; ok {
+			rv.ram += ram.Value(*m) * replicas
+		}
+		if cpu, ok := m.Resources["cpu"]; ok {
+			rv.cpu += cpu.Value(*m) * replicas
+		}
+	}
+	return rv
+}
+
+// Re-runs an evaluation, returning the resulting totals.
+func rerunTotals(e *Evaluation) (sensitivityTotals, error) {
+	if err := e.Run(); err != nil {
+		return sensitivityTotals{}, err
+	}
+	return evaluationTotals(e), nil
+}
+
+// Computes a finite difference from a baseline and a perturbed run.
+func newSensitivity(model, name string, input bool, value, delta float64, base, t sensitivityTotals) Sensitivity {
+	s := Sensitivity{Model: model, Name: name, Input: input, Value: value}
+	s.CPUPerUnit = (t.cpu - base.cpu) / delta
+	s.RAMPerUnit = (t.ram - base.ram) / delta
+	s.CPURounded = t.roundedCPU - base.roundedCPU
+	s.RAMRounded = t.roundedRAM - base.roundedRAM
+	if value == 0 {
+		s.NoPercent = true
+		return s
+	}
+	s.CPUPerPercent = t.cpu - base.cpu
+	s.RAMPerPercent = t.ram - base.ram
+	return s
+}
+
+// Returns the size of a 1% step from v. A value of 0 is stepped by
+// a whole unit, as 1% of nothing makes for a poor difference.
+func step(v float64) float64 {
+	if v == 0 {
+		return 1
+	}
+	return v * perturbation
+}
+
+// Perturbs every top-level input and every variable of every model by
+// 1%, one at a time, and records how the total CPU and RAM change,
+// before and after rounding up replica counts. Values of 0 are
+// stepped by a whole unit instead, and only have a change per unit, so
+// they sort after every value that moves the totals per 1%.
+func Sensitivities(g *Graph, inputs map[string]Expression) ([]Sensitivity, error) {
+	ev, err := g.Run(Scenario{Inputs: inputs})
+	if err != nil {
+		return nil, err
+	}
+	baseTotals := evaluationTotals(ev)
+	base := ev.Models()
+	top := base[g.Top()]
+	rv := []Sensitivity{}
+
+	for name, expr := range inputs {
+		value := expr.Value(*top)
+		delta := step(value)
+		ev.SetInput(name, constant{value + delta})
+		t, err := rerunTotals(ev)
+		if err != nil {
+			return nil, err
+		}
+		ev.SetInput(name, expr)
+		rv = append(rv, newSensitivity(g.Top(), name, true, value, delta, baseTotals, t))
+	}
+
+	for mName, m := range base {
+		for vName, v := range m.Variables {
+			value := v.Value(*m)
+			delta := step(value)
+			target := mName + "." + vName
+			ev.Override(target, constant{value + delta})
+			t, err := rerunTotals(ev)
+			if err != nil {
+				return nil, err
+			}
+			ev.ClearOverride(target)
+			rv = append(rv, newSensitivity(mName, vName, false, value, delta, baseTotals, t))
+		}
+	}
+
+	sort.Slice(rv, func(i, j int) bool {
+		ci := math.Abs(rv[i].CPUPerPercent)
+		cj := math.Abs(rv[j].CPUPerPercent)
+		if ci != cj {
+			return ci > cj
+		}
+		ri := math.Abs(rv[i].RAMPerPercent)
+		rj := math.Abs(rv[j].RAMPerPercent)
+		if ri != rj {
+			return ri > rj
+		}
+		if rv[i].Model != rv[j].Model {
+			return rv[i].Model < rv[j].Model
+		}
+		return rv[i].Name < rv[j].Name
+	})
+
+	return rv, nil
+}
+
+// Prints sensitivities, most significant first.
+func PrintSensitivities(w io.Writer, sens []Sensitivity) {
+	fmt.Fprintf(w, "sensitivities:\n")
+	for _, s := range sens {
+		kind := "variable"
+		if s.Input {
+			kind = "input"
+		}
+		fmt.Fprintf(w, "- name: %s.%s # %s\n", s.Model, s.Name, kind)
+		fmt.Fprintf(w, "  value: %f\n", s.Value)
+		if s.NoPercent {
+			fmt.Fprintf(w, "  cpu: %f # per unit, none per 1%% of 0, %f per unit rounded\n", s.CPUPerUnit, s.CPURounded)
+			fmt.Fprintf(w, "  ram: %f # per unit, none per 1%% of 0, %f per unit rounded\n", s.RAMPerUnit, s.RAMRounded)
+			continue
+		}
+		fmt.Fprintf(w, "  cpu: %f # per unit, %f per 1%%, %f per 1%% rounded\n", s.CPUPerUnit, s.CPUPerPercent, s.CPURounded)
+		fmt.Fprintf(w, "  ram: %f # per unit, %f per 1%%, %f per 1%% rounded\n", s.RAMPerUnit, s.RAMPerPercent, s.RAMRounded)
+	}
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func sensitivityModels() map[string]*Model {
+	top := New("top")
+	top.NewInput("qps")
+	top.Variables["qps_per_replica"] = newVariable("qps_per_replica", constant{100})
+	top.Resources["cpu"] = constant{2}
+	top.Resources["ram"] = constant{10}
+	top.Resources["replicas"] = operation{"/", reference{"qps"}, reference{"qps_per_replica"}}
+
+	return map[string]*Model{"top": top}
+}
+
+func TestSensitivities(t *testing.T) {
+	models := sensitivityModels()
+	inputs := map[string]Expression{"qps": constant{1000}}
+	g, err := NewGraph(models, "top")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+
+	seen, err := Sensitivities(g, inputs)
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if len(seen) != 2 {
+		t.Fatalf("Expected 2 sensitivities, saw %d", len(seen))
+	}
+
+	td := []struct {
+		name       string
+		input      bool
+		value      float64
+		cpuPercent float64
+		cpuUnit    float64
+		ramPercent float64
+		cpuRounded float64
+	}{
+		// 1010 qps needs 10.1 replicas, rounded up to 11
+		{"qps", true, 1000, 0.2, 0.02, 1, 2},
+		// 1000/101 needs 9.9 replicas, still rounded up to 10
+		{"qps_per_replica", false, 100, 2 * (1000/101.0 - 10), 2 * (1000/101.0 - 10), 10 * (1000/101.0 - 10), 0},
+	}
+
+	for ix, d := range td {
+		s := seen[ix]
+		if s.Name != d.name || s.Input != d.input || s.Model != "top" {
+			t.Errorf("test %d, saw %s.%s (input %v), expected top.%s", ix, s.Model, s.Name, s.Input, d.name)
+		}
+		if s.Value != d.value {
+			t.Errorf("test %d, saw value %f, expected %f", ix, s.Value, d.value)
+		}
+		if math.Abs(s.CPUPerPercent-d.cpuPercent) > 1e-6 {
+			t.Errorf("test %d, saw cpu %f per 1%%, expected %f", ix, s.CPUPerPercent, d.cpuPercent)
+		}
+		if math.Abs(s.CPUPerUnit-d.cpuUnit) > 1e-6 {
+			t.Errorf("test %d, saw cpu %f per unit, expected %f", ix, s.CPUPerUnit, d.cpuUnit)
+		}
+		if math.Abs(s.RAMPerPercent-d.ramPercent) > 1e-6 {
+			t.Errorf("test %d, saw ram %f per 1%%, expected %f", ix, s.RAMPerPercent, d.ramPercent)
+		}
+		if s.CPURounded != d.cpuRounded {
+			t.Errorf("test %d, saw cpu %f per 1%% rounded, expected %f", ix, s.CPURounded, d.cpuRounded)
+		}
+	}
+}
+
+func TestSensitivityOfZero(t *testing.T) {
+	models := sensitivityModels()
+	top := models["top"]
+	top.Variables["overhead"] = newVariable("overhead", constant{0})
+	top.Resources["cpu"] = operation{"+", constant{2}, reference{"overhead"}}
+	g, err := NewGraph(models, "top")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	seen, err := Sensitivities(g, map[string]Expression{"qps": constant{1000}})
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if len(seen) != 3 {
+		t.Fatalf("Expected 3 sensitivities, saw %d", len(seen))
+	}
+	// One more core for each of 10 replicas, but no 1% change of 0, so
+	// it sorts last
+	s := seen[2]
+	if s.Name != "overhead" || !s.NoPercent || s.CPUPerUnit != 10 || s.CPUPerPercent != 0 || s.CPURounded != 10 {
+		t.Errorf("Unexpected sensitivity %v", s)
+	}
+	if seen[0].NoPercent {
+		t.Errorf("Expected a change per 1%% of qps, saw %v", seen[0])
+	}
+
+	var buf bytes.Buffer
+	PrintSensitivities(&buf, seen)
+	if !strings.Contains(buf.String(), "- name: top.overhead # variable\n  value: 0.000000\n  cpu: 10.000000 # per unit, none per 1% of 0, 10.000000 per unit rounded\n") {
+		t.Errorf("Unexpected report\n%s", buf.String())
+	}
+}
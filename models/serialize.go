@@ -0,0 +1,160 @@
+// Converting models back to their serialization representation
+
+package models
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Returns the name of the input
+func (i Input) Name() string {
+	return i.name
+}
+
+// Returns the name of the model fed by the output
+func (o Output) Backend() string {
+	return o.backend
+}
+
+// Returns the name of the input on the backend fed by the output
+func (o Output) Input() string {
+	return o.input
+}
+
+// Returns the expression computing the output value
+func (o Output) Expression() Expression {
+	return o.value
+}
+
+// Converts a model back to its serialization representation. Inputs
+// are sorted by name, outputs keep their order.
+func ExternalFromModel(m *Model) ExternalModel {
+	rv := ExternalModel{Name: m.Name}
+	for name, _ := range m.Inputs {
+		rv.Inputs = append(rv.Inputs, name)
+	}
+	sort.Strings(rv.Inputs)
+	for _, o := range m.Outputs {
+		rv.Outputs = append(rv.Outputs, ExternalOutput{o.backend, o.input, o.value.String()})
+	}
+	if len(m.Variables) > 0 {
+		rv.Variables = make(map[string]string)
+		for name, v := range m.Variables {
+			rv.Variables[name] = v.expr.String()
+		}
+	}
+	if len(m.Resources) > 0 {
+		rv.Resources = make(map[string]string)
+		for name, r := range m.Resources {
+			rv.Resources[name] = r.String()
+		}
+	}
+	if !m.Policy.IsZero() {
+		p := m.Policy
+		rv.Policy = &p
+	}
+
+	return rv
+}
+
+// Converts a map of models back to their serialization
+// representation, sorted by name.
+func ExternalFromModels(models map[string]*Model) []ExternalModel {
+	rv := []ExternalModel{}
+	for _, name := range sortedModelNames(models) {
+		rv = append(rv, ExternalFromModel(models[name]))
+	}
+	return rv
+}
+
+// Returns a string as a YAML scalar, quoted only if it would not read
+// back as the same string otherwise.
+func yamlScalar(s string) string {
+	var check map[string]string
+	err := yaml.Unmarshal([]byte("k: "+s), &check)
+	if err == nil && check["k"] == s && s != "" {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+func sortedKeys(m map[string]string) []string {
+	rv := []string{}
+	for k, _ := range m {
+		rv = append(rv, k)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// Writes models as YAML, in the same format they are loaded from.
+// Sections are always written in the order name, inputs, variables,
+// outputs and resources. Empty sections are left out, and variables
+// and resources are sorted by name.
+func WriteExternalModels(w io.Writer, models []ExternalModel) error {
+	for ix, m := range models {
+		if ix > 0 {
+			if _, err := fmt.Fprintf(w, "\n"); err != nil {
+				return err
+			}
+		}
+		if err := writeExternalModel(w, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeExternalModel(w io.Writer, m ExternalModel) error {
+	lines := []string{fmt.Sprintf("- name: %s", yamlScalar(m.Name))}
+	if len(m.Inputs) > 0 {
+		lines = append(lines, "  inputs:")
+		for _, input := range m.Inputs {
+			lines = append(lines, fmt.Sprintf("   - %s", yamlScalar(input)))
+		}
+	}
+	if len(m.Variables) > 0 {
+		lines = append(lines, "  variables:")
+		for _, name := range sortedKeys(m.Variables) {
+			lines = append(lines, fmt.Sprintf("   %s: %s", yamlScalar(name), yamlScalar(m.Variables[name])))
+		}
+	}
+	if len(m.Outputs) > 0 {
+		lines = append(lines, "  outputs:")
+		for _, o := range m.Outputs {
+			lines = append(lines, fmt.Sprintf("   - backend: %s", yamlScalar(o.Backend)))
+			lines = append(lines, fmt.Sprintf("     input: %s", yamlScalar(o.Input)))
+			lines = append(lines, fmt.Sprintf("     expression: %s", yamlScalar(o.Expression)))
+		}
+	}
+	if len(m.Resources) > 0 {
+		lines = append(lines, "  resources:")
+		for _, name := range sortedKeys(m.Resources) {
+			lines = append(lines, fmt.Sprintf("   %s: %s", yamlScalar(name), yamlScalar(m.Resources[name])))
+		}
+	}
+	if m.Policy != nil && !m.Policy.IsZero() {
+		lines = append(lines, "  policy:")
+		if m.Policy.Redundancy != "" {
+			lines = append(lines, fmt.Sprintf("   redundancy: %s", yamlScalar(m.Policy.Redundancy)))
+		}
+		if m.Policy.TargetUtilization != 0 {
+			lines = append(lines, fmt.Sprintf("   target_utilization: %s", formatNumber(m.Policy.TargetUtilization)))
+		}
+		if m.Policy.MinReplicas != 0 {
+			lines = append(lines, fmt.Sprintf("   min_replicas: %d", m.Policy.MinReplicas))
+		}
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(w, "%s\n", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const roundTripModels = `- name: testmodel2
+  inputs:
+   - qps
+  variables:
+   qps_per_replica: 5000
+   lightweight_qps: qps * 0.99
+   heavyweight_qps: qps - lightweight_qps
+  resources:
+   ram: 5*1024*1024
+   cpu: 0.75
+   replicas: qps/qps_per_replica
+  outputs:
+   - backend: frontend
+     input: qps
+     expression: lightweight_qps
+   - backend: uploads
+     input: qps
+     expression: heavyweight_qps
+- name: frontend
+  inputs:
+   - qps
+   - "yes"
+   - "a: b"
+  variables:
+   qps_per_replica: normal(800, 50)
+   spread: 400..600
+  resources:
+   replicas: qps/qps_per_replica
+  policy:
+   redundancy: n+1
+   target_utilization: 0.6
+- name: uploads
+  inputs:
+   - qps
+`
+
+func loadModels(t *testing.T, data string) map[string]*Model {
+	ext, err := LoadExternalModels(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to load models, %s", err)
+	}
+	rv := make(map[string]*Model)
+	for _, e := range ext {
+		m, err := ModelFromExternal(e)
+		if err != nil {
+			t.Fatalf("Failed to convert model, %s", err)
+		}
+		rv[e.Name] = m
+	}
+	return rv
+}
+
+func TestExternalRoundTrip(t *testing.T) {
+	original := loadModels(t, roundTripModels)
+
+	var first bytes.Buffer
+	if err := WriteExternalModels(&first, ExternalFromModels(original)); err != nil {
+		t.Fatalf("Failed to write models, %s", err)
+	}
+	reloaded := loadModels(t, first.String())
+
+	if len(reloaded) != len(original) {
+		t.Fatalf("Saw %d models after round-trip, expected %d", len(reloaded), len(original))
+	}
+	for name, m := range original {
+		if status := cmpModels(m, reloaded[name]); status != "" {
+			t.Errorf("Model %s changed in round-trip, %s\n%s", name, status, first.String())
+		}
+	}
+
+	var second bytes.Buffer
+	WriteExternalModels(&second, ExternalFromModels(reloaded))
+	if first.String() != second.String() {
+		t.Errorf("Output not stable:\n%s\n%s", first.String(), second.String())
+	}
+}
+
+func TestYAMLScalar(t *testing.T) {
+	td := []struct {
+		s        string
+		expected string
+	}{
+		{"qps * 0.99", "qps * 0.99"},
+		{"500", "500"},
+		{"normal(800, 50)", "normal(800, 50)"},
+		{"", `""`},
+		{"a: b", `"a: b"`},
+		{"# comment", `"# comment"`},
+	}
+
+	for _, d := range td {
+		if seen := yamlScalar(d.s); seen != d.expected {
+			t.Errorf("Saw %s, expected %s", seen, d.expected)
+		}
+	}
+}
+
+func TestOutputAccessors(t *testing.T) {
+	m := New("test")
+	m.NewInput("qps")
+	m.NewOutput("backend", "in", constant{3})
+
+	o := m.Outputs[0]
+	if o.Backend() != "backend" || o.Input() != "in" || o.Expression().String() != "3" {
+		t.Errorf("Unexpected output accessors, %s %s %s", o.Backend(), o.Input(), o.Expression())
+	}
+	if name := m.Inputs["qps"].Name(); name != "qps" {
+		t.Errorf("Saw input name %s, expected qps", name)
+	}
+}
@@ -0,0 +1,145 @@
+// Symbolic manipulation of expressions
+
+package models
+
+// Returns the derivative of an expression with respect to the named
+// input or variable, as a new (simplified) expression. Variables are
+// differentiated through their expressions, any other reference is
+// taken to be independent of the name, even one naming a variable of
+// a model; see DeriveIn for expressions of a model.
+func Derive(e Expression, name string) Expression {
+	return Simplify(derive(e, name, nil, map[string]bool{}))
+}
+
+// Returns the derivative of an expression of a model with respect to
+// the named input or variable, following references to the variables
+// of the model into their expressions. References to inputs are not
+// followed, inputs shadowing variables of the same name.
+func DeriveIn(m *Model, e Expression, name string) Expression {
+	return Simplify(derive(e, name, m, map[string]bool{}))
+}
+
+// Derives an expression, resolving references to the variables of m,
+// if any. Variables in seen are being derived, and are not followed
+// again if they refer to themselves.
+func derive(e Expression, name string, m *Model, seen map[string]bool) Expression {
+	switch x := e.(type) {
+	case reference:
+		if x.name == name {
+			return constant{1}
+		}
+		if m == nil || seen[x.name] {
+			break
+		}
+		if _, isInput := m.Inputs[x.name]; isInput {
+			break
+		}
+		if v, ok := m.Variables[x.name]; ok {
+			seen[x.name] = true
+			defer delete(seen, x.name)
+			return derive(v.expr, name, m, seen)
+		}
+	case variable:
+		if x.name == name {
+			return constant{1}
+		}
+		return derive(x.expr, name, m, seen)
+	case operation:
+		dl := derive(x.left, name, m, seen)
+		dr := derive(x.right, name, m, seen)
+		switch x.operator {
+		case "+", "-":
+			return operation{x.operator, dl, dr}
+		case "*":
+			return operation{"+",
+				operation{"*", dl, x.right},
+				operation{"*", x.left, dr}}
+		case "/":
+			return operation{"/",
+				operation{"-",
+					operation{"*", dl, x.right},
+					operation{"*", x.left, dr}},
+				operation{"*", x.right, x.right}}
+		}
+	}
+	return constant{0}
+}
+
+// Returns true if the expression is the constant v.
+func isConstant(e Expression, v float64) bool {
+	c, ok := e.(constant)
+	return ok && c.value == v
+}
+
+// Returns a new, simplified, expression computing the same value.
+// Operations on constants are folded, and identities like x*1, x+0,
+// x-0, x/1, x*0 and 0/x are removed.
+func Simplify(e Expression) Expression {
+	switch x := e.(type) {
+	case variable:
+		return newVariable(x.name, Simplify(x.expr))
+	case operation:
+		return simplifyOperation(x.operator, Simplify(x.left), Simplify(x.right))
+	}
+	return e
+}
+
+func simplifyOperation(operator string, left, right Expression) Expression {
+	op := operation{operator, left, right}
+	lc, lConst := left.(constant)
+	_, rConst := right.(constant)
+	if lConst && rConst {
+		return constant{op.Value(Model{})}
+	}
+
+	switch operator {
+	case "+":
+		if isConstant(left, 0) {
+			return right
+		}
+		if isConstant(right, 0) {
+			return left
+		}
+		if rConst {
+			return simplifyOperation(operator, right, left)
+		}
+	case "-":
+		if isConstant(right, 0) {
+			return left
+		}
+	case "*":
+		if isConstant(left, 0) || isConstant(right, 0) {
+			return constant{0}
+		}
+		if isConstant(left, 1) {
+			return right
+		}
+		if isConstant(right, 1) {
+			return left
+		}
+		if rConst {
+			return simplifyOperation(operator, right, left)
+		}
+	case "/":
+		if isConstant(left, 0) {
+			return constant{0}
+		}
+		if isConstant(right, 1) {
+			return left
+		}
+	}
+
+	// Constants have been moved to the left of additions and
+	// multiplications above, fold them across nested operations of
+	// the same kind, so that 2*(3*x) becomes 6*x.
+	if operator == "+" || operator == "*" {
+		inner, ok := right.(operation)
+		if ok && inner.operator == operator && lConst {
+			if c, ok := inner.left.(constant); ok {
+				return simplifyOperation(operator, simplifyOperation(operator, lc, c), inner.right)
+			}
+		}
+	}
+
+	return op
+}
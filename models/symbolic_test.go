@@ -0,0 +1,111 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestSimplify(t *testing.T) {
+	x := reference{"x"}
+	td := []struct {
+		e        Expression
+		expected Expression
+	}{
+		{constant{3}, constant{3}},
+		{x, x},
+		{operation{"+", constant{1}, constant{2}}, constant{3}},
+		{operation{"+", x, constant{0}}, x},
+		{operation{"+", constant{0}, x}, x},
+		{operation{"-", x, constant{0}}, x},
+		{operation{"*", x, constant{1}}, x},
+		{operation{"*", constant{1}, x}, x},
+		{operation{"*", x, constant{0}}, constant{0}},
+		{operation{"/", x, constant{1}}, x},
+		{operation{"/", constant{0}, x}, constant{0}},
+		{operation{"+", x, constant{2}}, operation{"+", constant{2}, x}},
+		{operation{"*", constant{2}, operation{"*", x, constant{3}}},
+			operation{"*", constant{6}, x}},
+		{operation{"*", operation{"+", constant{1}, constant{1}}, operation{"-", x, operation{"*", constant{0}, x}}},
+			operation{"*", constant{2}, x}},
+		{newVariable("v", operation{"*", constant{1}, x}), newVariable("v", x)},
+	}
+
+	for ix, d := range td {
+		seen := Simplify(d.e)
+		if !compareExpr(seen, d.expected) {
+			t.Errorf("test %d, saw %v, expected %v", ix, seen, d.expected)
+		}
+	}
+}
+
+func TestDerive(t *testing.T) {
+	x := reference{"x"}
+	y := reference{"y"}
+	td := []struct {
+		e        Expression
+		expected Expression
+	}{
+		{constant{3}, constant{0}},
+		{x, constant{1}},
+		{y, constant{0}},
+		{operation{"+", x, y}, constant{1}},
+		{operation{"-", y, x}, constant{-1}},
+		{operation{"*", constant{3}, x}, constant{3}},
+		{operation{"*", x, y}, y},
+		{operation{"*", x, x}, operation{"+", x, x}},
+		{operation{"/", y, x},
+			operation{"/",
+				operation{"-", constant{0}, y},
+				operation{"*", x, x}}},
+		{newVariable("v", operation{"*", constant{2}, x}), constant{2}},
+		{newVariable("x", constant{4}), constant{1}},
+	}
+
+	for ix, d := range td {
+		seen := Derive(d.e, "x")
+		if !compareExpr(seen, d.expected) {
+			t.Errorf("test %d, saw %v, expected %v", ix, seen, d.expected)
+		}
+	}
+}
+
+func TestDeriveValue(t *testing.T) {
+	m := Model{}
+	m.Inputs = map[string]Input{}
+	m.NewInput("x")
+	m.SetInput("x", "test", 3.0)
+
+	// d/dx (x*x + 4*x) / 2 = x + 2
+	e := operation{"/",
+		operation{"+",
+			operation{"*", reference{"x"}, reference{"x"}},
+			operation{"*", constant{4}, reference{"x"}}},
+		constant{2}}
+	seen := Derive(e, "x").Value(m)
+	if seen != 5.0 {
+		t.Errorf("Saw %f, expected 5", seen)
+	}
+}
+
+func TestDeriveIn(t *testing.T) {
+	m := New("back")
+	m.NewInput("qps")
+	m.SetInput("qps", "test", 500.0)
+	m.Variables["doubled"] = newVariable("doubled", operation{"*", reference{"qps"}, constant{2}})
+	m.Variables["per_replica"] = newVariable("per_replica", constant{100})
+	m.Variables["loop"] = newVariable("loop", operation{"+", reference{"loop"}, reference{"qps"}})
+	replicas := operation{"/", reference{"doubled"}, reference{"per_replica"}}
+
+	// Derive does not look into the variables of the model
+	if seen := Derive(replicas, "qps"); !isConstant(seen, 0) {
+		t.Errorf("Saw %v, expected 0 without the model", seen)
+	}
+	if seen := DeriveIn(m, replicas, "qps").Value(*m); seen != 0.02 {
+		t.Errorf("Saw %f, expected 0.02", seen)
+	}
+	if seen := DeriveIn(m, replicas, "doubled").Value(*m); seen != 0.01 {
+		t.Errorf("Saw %f, expected 0.01", seen)
+	}
+	if seen := DeriveIn(m, reference{"loop"}, "qps").Value(*m); seen != 1 {
+		t.Errorf("Saw %f, expected 1 for a variable referring to itself", seen)
+	}
+}
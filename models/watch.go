@@ -0,0 +1,77 @@
+// Watching model files for changes
+
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Returns a fingerprint of the model files for a file or directory,
+// changing whenever a file is added, removed or modified.
+func modelFingerprint(filename string) (string, error) {
+	files, err := modelFiles(filename)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %d\n", file, len(data))
+		h.Write(data)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// Watches a model file, or directory, for changes.
+type Watcher struct {
+	filename string
+	last     string
+}
+
+// Creates a watcher, noting the current state of the files. Changes
+// made after it is created are picked up by Run, so it should be
+// created before the graph is first loaded.
+func NewWatcher(filename string) *Watcher {
+	last, err := modelFingerprint(filename)
+	if err != nil {
+		last = err.Error()
+	}
+	return &Watcher{filename: filename, last: last}
+}
+
+// Polls the files at the given interval until the context is done.
+// Whenever the files change, the graph is loaded again, see
+// LoadGraph, and passed to reload, or the error if it failed to load.
+// Files that cannot be read at all are reported the same way, once
+// until they change again.
+func (w *Watcher) Run(ctx context.Context, interval time.Duration, reload func(*Graph, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		current, err := modelFingerprint(w.filename)
+		if err != nil {
+			current = err.Error()
+		}
+		if current == w.last {
+			continue
+		}
+		w.last = current
+		if err != nil {
+			reload(nil, err)
+			continue
+		}
+		reload(LoadGraph(w.filename))
+	}
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type reloaded struct {
+	g   *Graph
+	err error
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "planning")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	defer os.RemoveAll(dir)
+	top := filepath.Join(dir, "front")
+	os.Mkdir(top, 0755)
+	// Files are replaced by renaming, so the watcher never sees them
+	// half-written
+	write := func(name, data string) {
+		tmp := filepath.Join(top, name+".tmp")
+		if err := ioutil.WriteFile(tmp, []byte(data), 0644); err != nil {
+			t.Fatalf("Unexpected error, %s", err)
+		}
+		if err := os.Rename(tmp, filepath.Join(top, name)); err != nil {
+			t.Fatalf("Unexpected error, %s", err)
+		}
+	}
+	write("front.yaml", "- name: front\n  inputs:\n   - qps\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	seen := make(chan reloaded, 10)
+	done := make(chan bool)
+	w := NewWatcher(top)
+	go func() {
+		w.Run(ctx, time.Millisecond, func(g *Graph, err error) {
+			seen <- reloaded{g, err}
+		})
+		close(done)
+	}()
+	next := func() reloaded {
+		select {
+		case r := <-seen:
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for a reload")
+		}
+		return reloaded{}
+	}
+
+	write("back.yaml", "- name: back\n  inputs:\n   - qps\n")
+	if r := next(); r.err != nil || len(r.g.Names()) != 2 {
+		t.Errorf("Saw %v after adding a model, expected two models", r)
+	}
+
+	write("back.yaml", "- name: back\n  inputs:\n   - qps\n  resources:\n   replicas: qps /\n")
+	if r := next(); r.err == nil {
+		t.Errorf("Expected an error after a typo in an expression")
+	}
+
+	write("back.yaml", "- name: back\n  inputs:\n   - qps\n  resources:\n   replicas: qps / qps_per_replca\n")
+	if r := next(); r.err == nil {
+		t.Errorf("Expected an error after a typo in a reference")
+	}
+
+	write("back.yaml", "- name: back\n  inputs:\n   - qps\n  variables:\n   a: b\n   b: a\n")
+	if r := next(); r.err == nil {
+		t.Errorf("Expected an error after adding circular variables")
+	}
+
+	write("back.yaml", "- name: back\n  inputs:\n   - qps\n  outputs:\n   - backend: nope\n     input: qps\n     expression: 1\n")
+	if r := next(); r.err == nil {
+		t.Errorf("Expected an error after adding an output to an unknown model")
+	}
+
+	os.Remove(filepath.Join(top, "back.yaml"))
+	if r := next(); r.err != nil || len(r.g.Names()) != 1 {
+		t.Errorf("Saw %v after removing a model, expected one model", r)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Watch did not return after cancelling")
+	}
+	if len(seen) != 0 {
+		t.Errorf("Saw %d unexpected reloads", len(seen))
+	}
+}
@@ -1,16 +1,84 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/vatine/planning/models"
+	"github.com/vatine/planning/server"
 )
 
 func help(prog string) {
-	fmt.Printf("%s <inputspec>... <file>\n\n\tinputspec should be <input>=<number>\n", prog)
+	fmt.Printf("%s [sensitivity|montecarlo] [--option=value]... <inputspec>... <file>\n", prog)
+	fmt.Printf("%s explain <model>[.<name>]... <inputspec>... <file>\n", prog)
+	fmt.Printf("%s forecast --growth=<file> [--months=<n>] <inputspec>... <file>\n", prog)
+	fmt.Printf("%s peak --profiles=<file> <inputspec>... <file>\n", prog)
+	fmt.Printf("%s repl <inputspec>... <file or directory>\n", prog)
+	fmt.Printf("%s serve [--addr=<address>] [--poll=<seconds>] <file or directory>\n", prog)
+	fmt.Printf("%s watch [--poll=<seconds>] <inputspec>... <file or directory>\n", prog)
+	fmt.Printf("%s fmt [--check] <file>...\n\n\tinputspec should be <input>=<number> or <input>=<min>..<max>\n", prog)
+	fmt.Println()
+	fmt.Println("\tWith sensitivity, report how total CPU and RAM respond to a")
+	fmt.Println("\t1% change in each top-level input and each model variable.")
+	fmt.Println()
+	fmt.Println("\tWith explain, show how each resource, variable or input named")
+	fmt.Println("\twas derived, down to where every input value came from.")
+	fmt.Println()
+	fmt.Println("\tWith forecast, evaluate the models every month over a horizon")
+	fmt.Println("\t(default 24 months, or --months), with the inputs growing as")
+	fmt.Println("\tgiven in the --growth file, and show the replicas, RAM and CPU")
+	fmt.Println("\tof each model every month, and when they cross thresholds.")
+	fmt.Println()
+	fmt.Println("\tWith peak, evaluate the models once per time slot of the")
+	fmt.Println("\ttraffic profiles in the --profiles file, and show the peak and")
+	fmt.Println("\taverage of each model, and the slot it peaks in.")
+	fmt.Println()
+	fmt.Println("\tWith repl, load the models and read commands to change inputs")
+	fmt.Println("\tand overrides, and show, explain and evaluate the results.")
+	fmt.Println("\tType help for the commands.")
+	fmt.Println()
+	fmt.Println("\tWith serve, load the models and serve a JSON API for listing")
+	fmt.Println("\tthem, fetching their definitions and dependency graph, and")
+	fmt.Println("\tevaluating scenarios. The default address is :8080.")
+	fmt.Println()
+	fmt.Println("\tWith watch, print the plan, and print it again whenever the")
+	fmt.Println("\tmodel files change. Both serve and watch check the files every")
+	fmt.Println("\t--poll seconds (default 2, 0 to never reload), and keep the last")
+	fmt.Println("\tgood models if the changed files fail to load.")
+	fmt.Println()
+	fmt.Println("\tWith fmt, rewrite model files in their canonical format. With")
+	fmt.Println("\t--check, only list the files that are not formatted, and fail")
+	fmt.Println("\tif there are any.")
+	fmt.Println()
+	fmt.Println("\tWith montecarlo, evaluate the models repeatedly, sampling every")
+	fmt.Println("\tdistribution, and report percentiles. Options are --samples=<n>")
+	fmt.Println("\t(default 1000) and --seed=<n> (default 1).")
+	fmt.Println()
+	fmt.Println("\tWith --pricing=<file>, the plan, repl, watch and serve modes")
+	fmt.Println("\tshow the monthly cost of each model and in total.")
+	fmt.Println()
+	fmt.Println("\tWith --policy=<file>, apply the redundancy and headroom policy")
+	fmt.Println("\tin the file to every model, for the parts of the policy that the")
+	fmt.Println("\tmodel does not set itself.")
+	fmt.Println()
+	fmt.Println("\tWith --shapes=<file>, the plan also shows how many machines of")
+	fmt.Println("\teach shape in the file are needed to host every replica.")
+	fmt.Println()
+	fmt.Println("\tWith --regions=<file>, the plan also shows the replicas each")
+	fmt.Println("\tmodel needs in each region, given its share of the traffic, and")
+	fmt.Println("\tto take over its part of the traffic of any one lost region.")
+	fmt.Println("\tOnly the inputs listed as traffic in the file are split between")
+	fmt.Println("\tthe regions, or every input if none are listed.")
+	fmt.Println()
+	fmt.Println("\tWith --inventory=<file>, the plan also compares the replicas,")
+	fmt.Println("\tRAM and CPU of each model against what is deployed, as listed")
+	fmt.Println("\tin the file (YAML, or CSV if it ends in .csv), and gives a verdict.")
 	fmt.Println()
 	fmt.Println("\tThe model file should be a YAML-formatted list of server models")
 	fmt.Println("\tEach model should follow the following format:")
@@ -19,18 +87,57 @@ func help(prog string) {
 	fmt.Println("\tvariables:\n\t <varname>: <expression>\n\t  ...")
 	fmt.Println("\tresources:\n\t ram: <expression>\n\t cpu: <expression>")
 	fmt.Println("\t replicas: <expression>")
+	fmt.Println("\tpolicy:\n\t redundancy: n+<k>\n\t target_utilization: <fraction>")
+	fmt.Println("\t min_replicas: <number>")
+}
+
+// Applies a global policy, if there is one, to a freshly loaded graph
+func applyPolicy(policy *models.Policy, g *models.Graph, err error) (*models.Graph, error) {
+	if err != nil || policy == nil {
+		return g, err
+	}
+	return g.WithPolicy(*policy)
+}
+
+// Returns the integer value of a command-line option, or a default
+// if the option is missing or not an integer.
+func intOption(options map[string]string, name string, def int) int {
+	v, ok := options[name]
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		fmt.Printf("Ignoring --%s=%s, %s\n", name, v, err)
+		return def
+	}
+	return n
 }
 
 func main() {
 	inputs := make(map[string]models.Expression)
-	usage := make(map[string]*models.Model)
 	var filename string
+	positional := []string{}
+	mode := "plan"
+	options := make(map[string]string)
 
 	for _, arg := range os.Args[1:] {
 		if arg == "help" {
 			help(path.Base(os.Args[0]))
 			return
 		}
+		if arg == "sensitivity" || arg == "montecarlo" || arg == "explain" || arg == "fmt" || arg == "repl" || arg == "serve" || arg == "watch" || arg == "forecast" || arg == "peak" {
+			mode = arg
+			continue
+		}
+		if strings.HasPrefix(arg, "--") {
+			tmp := strings.SplitN(arg[2:], "=", 2)
+			if len(tmp) == 1 {
+				tmp = append(tmp, "")
+			}
+			options[tmp[0]] = tmp[1]
+			continue
+		}
 		if strings.Index(arg, "=") != -1 {
 			// we have an input!
 			tmp := strings.Split(arg, "=")
@@ -43,34 +150,206 @@ func main() {
 				inputs[name] = value
 			}
 		} else {
-			filename = arg
+			positional = append(positional, arg)
+		}
+	}
+	if mode == "fmt" {
+		_, check := options["check"]
+		if !formatFiles(positional, check) {
+			os.Exit(1)
+		}
+		return
+	}
+	if len(positional) > 0 {
+		filename = positional[len(positional)-1]
+	}
+
+	var pricing *models.Pricing
+	if pricingFile, ok := options["pricing"]; ok {
+		p, err := models.LoadPricing(pricingFile)
+		if err != nil {
+			fmt.Printf("Error loading pricing, %s\n", err)
+			return
+		}
+		pricing = &p
+	}
+
+	var policy *models.Policy
+	if policyFile, ok := options["policy"]; ok {
+		p, err := models.LoadPolicy(policyFile)
+		if err != nil {
+			fmt.Printf("Error loading policy, %s\n", err)
+			return
+		}
+		policy = &p
+	}
+
+	var catalogue *models.Catalogue
+	if shapesFile, ok := options["shapes"]; ok {
+		c, err := models.LoadCatalogue(shapesFile)
+		if err != nil {
+			fmt.Printf("Error loading shapes, %s\n", err)
+			return
+		}
+		catalogue = &c
+	}
+
+	var regions *models.RegionSpec
+	if regionsFile, ok := options["regions"]; ok {
+		r, err := models.LoadRegions(regionsFile)
+		if err != nil {
+			fmt.Printf("Error loading regions, %s\n", err)
+			return
 		}
+		regions = &r
 	}
 
-	base := path.Base(filename)
-	switch {
-	case strings.HasSuffix(base, ".yaml"):
-		base = base[:len(base)-5]
-	case strings.HasSuffix(base, ".yml"):
-		base = base[:len(base)-4]
+	var inventory []models.Deployed
+	if inventoryFile, ok := options["inventory"]; ok {
+		inv, err := models.LoadInventory(inventoryFile)
+		if err != nil {
+			fmt.Printf("Error loading inventory, %s\n", err)
+			return
+		}
+		inventory = inv
 	}
 
-	f, fErr := os.Open(filename)
-	if fErr != nil {
-		fmt.Printf("Error opening %s, %s", filename, fErr)
+	// The watcher notes the files before they are first loaded, so no
+	// change is missed
+	var watcher *models.Watcher
+	poll := time.Duration(intOption(options, "poll", 2)) * time.Second
+	if (mode == "serve" || mode == "watch") && poll > 0 {
+		watcher = models.NewWatcher(filename)
+	}
+	graph, err := models.LoadGraph(filename)
+	graph, err = applyPolicy(policy, graph, err)
+	if mode == "watch" {
+		watchModels(filename, watcher, poll, graph, err, inputs, pricing, policy)
+		return
 	}
-	defer f.Close()
-	usageModel, err := models.LoadExternalModels(f)
 	if err != nil {
-		fmt.Printf("Error loading models, %s", err)
+		fmt.Printf("Error in models, %s\n", err)
+		return
 	}
-	for _, m := range usageModel {
-		usage[m.Name] = models.ModelFromExternal(m)
+	switch mode {
+	case "sensitivity":
+		sens, err := models.Sensitivities(graph, inputs)
+		if err != nil {
+			fmt.Printf("Failed to compute sensitivities, %s\n", err)
+			return
+		}
+		models.PrintSensitivities(os.Stdout, sens)
+		return
+	case "serve":
+		addr, ok := options["addr"]
+		if !ok {
+			addr = ":8080"
+		}
+		srv := server.New(graph, pricing)
+		if watcher != nil {
+			go watcher.Run(context.Background(), poll, func(g *models.Graph, err error) {
+				g, err = applyPolicy(policy, g, err)
+				if err != nil {
+					fmt.Printf("Failed to reload %s, keeping the last good version, %s\n", filename, err)
+					srv.SetLoadError(err)
+					return
+				}
+				fmt.Printf("Reloaded %s\n", filename)
+				srv.SetGraph(g)
+			})
+		}
+		fmt.Printf("Serving %s on %s\n", filename, addr)
+		if err := http.ListenAndServe(addr, srv); err != nil {
+			fmt.Printf("Failed to serve, %s\n", err)
+		}
+		return
+	case "repl":
+		r, err := newREPL(graph, inputs, pricing, os.Stdout)
+		if err != nil {
+			fmt.Printf("Failed to evaluate, %s\n", err)
+			return
+		}
+		r.run(os.Stdin)
+		return
+	case "forecast":
+		growthFile, ok := options["growth"]
+		if !ok {
+			fmt.Println("Forecasting needs a --growth file")
+			return
+		}
+		spec, err := models.LoadForecastSpec(growthFile)
+		if err != nil {
+			fmt.Printf("Error loading growth, %s\n", err)
+			return
+		}
+		spec.Months = intOption(options, "months", spec.Months)
+		forecast, err := spec.Run(graph, inputs)
+		if err != nil {
+			fmt.Printf("Failed to forecast, %s\n", err)
+			return
+		}
+		models.PrintForecast(os.Stdout, forecast)
+		return
+	case "peak":
+		profilesFile, ok := options["profiles"]
+		if !ok {
+			fmt.Println("Peak sizing needs a --profiles file")
+			return
+		}
+		profiles, err := models.LoadProfiles(profilesFile)
+		if err != nil {
+			fmt.Printf("Error loading profiles, %s\n", err)
+			return
+		}
+		report, err := profiles.Run(graph, inputs)
+		if err != nil {
+			fmt.Printf("Failed to evaluate profiles, %s\n", err)
+			return
+		}
+		models.PrintProfile(os.Stdout, report)
+		return
+	case "montecarlo":
+		samples := intOption(options, "samples", 1000)
+		seed := intOption(options, "seed", 1)
+		report, err := models.MonteCarlo(graph, inputs, samples, int64(seed))
+		if err != nil {
+			fmt.Printf("Failed to run Monte Carlo evaluation, %s\n", err)
+			return
+		}
+		models.PrintMonteCarlo(os.Stdout, report)
+		return
 	}
-	propagateErr := models.Propagate(usage, base, inputs)
+	usage, propagateErr := graph.Evaluate(inputs)
 	if propagateErr != nil {
-		fmt.Printf("Failed to propagate, %s\nModel hash is %v\n", propagateErr, usage)
+		fmt.Printf("Failed to propagate, %s\n", propagateErr)
 		return
 	}
-	models.PrintModels(os.Stdout, usage)
+	if mode == "explain" {
+		for _, target := range positional[:len(positional)-1] {
+			if err := models.Explain(os.Stdout, usage, target); err != nil {
+				fmt.Printf("Failed to explain %s, %s\n", target, err)
+			}
+		}
+		return
+	}
+	models.PrintModels(os.Stdout, usage, pricing)
+	if catalogue != nil {
+		packing, err := catalogue.Pack(usage)
+		if err != nil {
+			fmt.Printf("Failed to pack replicas onto machines, %s\n", err)
+			return
+		}
+		models.PrintPacking(os.Stdout, packing)
+	}
+	if regions != nil {
+		plans, err := regions.Plan(graph, inputs)
+		if err != nil {
+			fmt.Printf("Failed to plan regions, %s\n", err)
+			return
+		}
+		models.PrintRegions(os.Stdout, plans)
+	}
+	if inventory != nil {
+		models.PrintGapReport(os.Stdout, models.CompareInventory(usage, inventory))
+	}
 }
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/vatine/planning/models"
+)
+
+// An interactive session, exploring a single evaluation of a graph.
+type repl struct {
+	out     io.Writer
+	eval    *models.Evaluation
+	pricing *models.Pricing
+	history []string
+}
+
+func replHelp(w io.Writer) {
+	fmt.Fprintln(w, "set <input>=<expression>...         set top-level inputs")
+	fmt.Fprintln(w, "override <model>.<var>=<expression> override a variable")
+	fmt.Fprintln(w, "clear <model>.<var>                 remove an override")
+	fmt.Fprintln(w, "show [<model>]...                   show models, or all of them")
+	fmt.Fprintln(w, "explain <model>[.<name>]...         explain how values were derived")
+	fmt.Fprintln(w, "eval <model>: <expression>          evaluate in a model's scope")
+	fmt.Fprintln(w, "totals                              show total RAM and CPU")
+	fmt.Fprintln(w, "history                             list earlier commands")
+	fmt.Fprintln(w, "!<n>                                repeat command n from history")
+	fmt.Fprintln(w, "quit                                leave")
+}
+
+// Creates a session, evaluating the graph with the given inputs. With
+// pricing, models and totals are shown with their monthly cost.
+func newREPL(g *models.Graph, inputs map[string]models.Expression, pricing *models.Pricing, out io.Writer) (*repl, error) {
+	e, err := g.Run(models.Scenario{Inputs: inputs})
+	if err != nil {
+		return nil, err
+	}
+	return &repl{out: out, eval: e, pricing: pricing}, nil
+}
+
+// Splits "name=expression" and parses the expression.
+func assignment(arg string) (string, models.Expression, error) {
+	tmp := strings.SplitN(arg, "=", 2)
+	if len(tmp) != 2 {
+		return "", nil, errors.New(fmt.Sprintf("Expected <name>=<expression>, saw %s", arg))
+	}
+	value, err := models.Parse(strings.TrimSpace(tmp[1]))
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.TrimSpace(tmp[0]), value, nil
+}
+
+// Returns true if c can be part of a name
+func isNameChar(c byte) bool {
+	return c == '_' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// Splits "a=<expression> b = <expression>" into one string per
+// assignment. A new assignment starts at the name before every "="
+// outside parentheses, so expressions can hold spaces and commas.
+func splitAssignments(s string) []string {
+	starts := []int{0}
+	depth := 0
+	for ix := 0; ix < len(s); ix++ {
+		switch s[ix] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '=':
+			if depth != 0 || ix == 0 {
+				continue
+			}
+			start := ix
+			for start > 0 && (s[start-1] == ' ' || s[start-1] == '\t') {
+				start--
+			}
+			for start > 0 && isNameChar(s[start-1]) {
+				start--
+			}
+			if start > starts[len(starts)-1] {
+				starts = append(starts, start)
+			}
+		}
+	}
+	rv := []string{}
+	for ix, start := range starts {
+		end := len(s)
+		if ix+1 < len(starts) {
+			end = starts[ix+1]
+		}
+		if arg := strings.TrimSpace(s[start:end]); arg != "" {
+			rv = append(rv, arg)
+		}
+	}
+	return rv
+}
+
+// Re-evaluates what changed, and reports which models were affected.
+func (r *repl) recompute() {
+	recomputed, err := r.eval.Recompute()
+	if err != nil {
+		fmt.Fprintf(r.out, "Failed to evaluate, %s\n", err)
+		return
+	}
+	fmt.Fprintf(r.out, "recomputed: %s\n", strings.Join(recomputed, ", "))
+}
+
+// Checks that an override target names a variable of a model, so a
+// typo is never stored in the scenario.
+func (r *repl) checkTarget(target string) error {
+	parts := strings.SplitN(target, ".", 2)
+	m, ok := r.eval.Models()[parts[0]]
+	if !ok || len(parts) != 2 {
+		return errors.New(fmt.Sprintf("Cannot override %s, no such model", target))
+	}
+	if _, ok := m.Variables[parts[1]]; !ok {
+		return errors.New(fmt.Sprintf("Cannot override %s, no such variable", target))
+	}
+	return nil
+}
+
+// Checks that a name is an input of the top-level model, so a typo
+// is never stored in the scenario.
+func (r *repl) checkInput(name string) error {
+	top := r.eval.Models()[r.eval.Graph().Top()]
+	if _, ok := top.Inputs[name]; !ok {
+		return errors.New(fmt.Sprintf("Cannot set %s, no such input", name))
+	}
+	return nil
+}
+
+// Runs a single command. Returns false when the session should end.
+func (r *repl) command(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return true
+	}
+	if strings.HasPrefix(line, "!") {
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 1 || n > len(r.history) {
+			fmt.Fprintf(r.out, "No command %s in history\n", line[1:])
+			return true
+		}
+		line = r.history[n-1]
+		fmt.Fprintln(r.out, line)
+	}
+	r.history = append(r.history, line)
+
+	fields := strings.Fields(line)
+	cmd, args := fields[0], fields[1:]
+	switch cmd {
+	case "quit", "exit":
+		return false
+	case "help":
+		replHelp(r.out)
+	case "set":
+		// Every assignment is checked before any is made
+		values := make(map[string]models.Expression)
+		for _, arg := range splitAssignments(strings.TrimSpace(line[len(cmd):])) {
+			name, value, err := assignment(arg)
+			if err != nil {
+				fmt.Fprintf(r.out, "Failed to parse %s, %s\n", arg, err)
+				return true
+			}
+			if err := r.checkInput(name); err != nil {
+				fmt.Fprintf(r.out, "%s\n", err)
+				return true
+			}
+			values[name] = value
+		}
+		for name, value := range values {
+			r.eval.SetInput(name, value)
+		}
+		r.recompute()
+	case "override":
+		name, value, err := assignment(strings.Join(args, " "))
+		if err != nil {
+			fmt.Fprintf(r.out, "Failed to parse override, %s\n", err)
+			return true
+		}
+		if err := r.checkTarget(name); err != nil {
+			fmt.Fprintf(r.out, "%s\n", err)
+			return true
+		}
+		r.eval.Override(name, value)
+		r.recompute()
+	case "clear":
+		for _, target := range args {
+			r.eval.ClearOverride(target)
+		}
+		r.recompute()
+	case "show":
+		if len(args) == 0 {
+			models.PrintModels(r.out, r.eval.Models(), r.pricing)
+		}
+		for _, name := range args {
+			m, ok := r.eval.Models()[name]
+			if !ok {
+				fmt.Fprintf(r.out, "No model named %s\n", name)
+				continue
+			}
+			models.PrintModel(r.out, m, r.pricing)
+		}
+	case "explain":
+		for _, target := range args {
+			if err := models.Explain(r.out, r.eval.Models(), target); err != nil {
+				fmt.Fprintf(r.out, "Failed to explain %s, %s\n", target, err)
+			}
+		}
+	case "eval":
+		rest := strings.TrimSpace(line[len(cmd):])
+		tmp := strings.SplitN(rest, ":", 2)
+		if len(tmp) != 2 {
+			fmt.Fprintf(r.out, "Expected eval <model>: <expression>\n")
+			return true
+		}
+		expr, err := models.Parse(strings.TrimSpace(tmp[1]))
+		if err != nil {
+			fmt.Fprintf(r.out, "Failed to parse %s, %s\n", tmp[1], err)
+			return true
+		}
+		v, span, err := r.eval.Eval(strings.TrimSpace(tmp[0]), expr)
+		if err != nil {
+			fmt.Fprintf(r.out, "Failed to evaluate, %s\n", err)
+			return true
+		}
+		if span.Wide() {
+			fmt.Fprintf(r.out, "%f # range %s\n", v, span)
+		} else {
+			fmt.Fprintf(r.out, "%f\n", v)
+		}
+	case "totals":
+		models.PrintTotals(r.out, r.eval.Models(), r.pricing)
+	case "history":
+		for ix, past := range r.history[:len(r.history)-1] {
+			fmt.Fprintf(r.out, "%d %s\n", ix+1, past)
+		}
+	default:
+		fmt.Fprintf(r.out, "Unknown command %s, try help\n", cmd)
+	}
+	return true
+}
+
+// Reads and runs commands until the input ends or the session is
+// quit.
+func (r *repl) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	fmt.Fprint(r.out, "> ")
+	for scanner.Scan() {
+		if !r.command(scanner.Text()) {
+			return
+		}
+		fmt.Fprint(r.out, "> ")
+	}
+	fmt.Fprintln(r.out)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/vatine/planning/models"
+)
+
+const replModels = `- name: top
+  inputs:
+   - qps
+  outputs:
+   - backend: back
+     input: qps
+     expression: qps * 2
+- name: back
+  inputs:
+   - qps
+  variables:
+   per_replica: 100
+  resources:
+   replicas: qps / per_replica
+   cpu: 2
+`
+
+func TestREPL(t *testing.T) {
+	ext, err := models.LoadExternalModels(strings.NewReader(replModels))
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	g, err := models.GraphFromExternal(ext, "top")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	var out bytes.Buffer
+	r, err := newREPL(g, map[string]models.Expression{}, nil, &out)
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+
+	td := []struct {
+		line     string
+		expected string
+	}{
+		{"set qps=500", "recomputed: top, back\n"},
+		{"eval back: qps / 10", "100.000000\n"},
+		{"override back.per_replica=50", "recomputed: back\n"},
+		{"eval back: per_replica", "50.000000\n"},
+		{"totals", "totals:\n ram: 0.000000\n cpu: 40.000000\n"},
+		{"clear back.per_replica", "recomputed: back\n"},
+		{"!2", "eval back: qps / 10\n100.000000\n"},
+		{"history", "1 set qps=500\n2 eval back: qps / 10\n3 override back.per_replica=50\n4 eval back: per_replica\n5 totals\n6 clear back.per_replica\n7 eval back: qps / 10\n"},
+		{"eval back: nope", "Failed to evaluate, Model back has no input or variable nope\n"},
+		{"frobnicate", "Unknown command frobnicate, try help\n"},
+		{"override back.nosuchvar=1", "Cannot override back.nosuchvar, no such variable\n"},
+		{"override nope.per_replica=1", "Cannot override nope.per_replica, no such model\n"},
+		{"set qps=1000", "recomputed: top, back\n"},
+		{"eval back: qps", "2000.000000\n"},
+		{"set qsp=6000", "Cannot set qsp, no such input\n"},
+		{"eval back: qps", "2000.000000\n"},
+		{"set qps = 1500", "recomputed: top, back\n"},
+		{"eval back: qps", "3000.000000\n"},
+		{"set qps=normal(2000, 100)", "recomputed: top, back\n"},
+		{"eval back: qps", "4000.000000 # range 3400.000000..4600.000000\n"},
+	}
+
+	for ix, d := range td {
+		out.Reset()
+		if !r.command(d.line) {
+			t.Fatalf("test %d, unexpected end of session", ix)
+		}
+		if seen := out.String(); seen != d.expected {
+			t.Errorf("test %d, saw %q, expected %q", ix, seen, d.expected)
+		}
+	}
+	if r.command("quit") {
+		t.Errorf("Expected quit to end the session")
+	}
+}
+
+func TestSplitAssignments(t *testing.T) {
+	td := []struct {
+		line     string
+		expected []string
+	}{
+		{"qps=500", []string{"qps=500"}},
+		{"qps = 500 size=2", []string{"qps = 500", "size=2"}},
+		{"qps=normal(5000, 500) size = 1..2", []string{"qps=normal(5000, 500)", "size = 1..2"}},
+		{"qps", []string{"qps"}},
+	}
+	for ix, d := range td {
+		seen := splitAssignments(d.line)
+		if strings.Join(seen, "|") != strings.Join(d.expected, "|") {
+			t.Errorf("test %d, saw %q, expected %q", ix, seen, d.expected)
+		}
+	}
+}
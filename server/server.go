@@ -0,0 +1,274 @@
+// An HTTP JSON API for evaluating capacity models
+
+package server
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vatine/planning/models"
+)
+
+//go:embed ui/index.html
+var ui embed.FS
+
+// Serves a graph of models over HTTP. The endpoints are:
+//
+//	GET  /               a what-if page, using the endpoints below
+//	GET  /models         the top-level model and the names of all models
+//	GET  /models/<name>  the definition of a model
+//	GET  /graph          the models, the edges between them and their layers
+//	POST /plan           evaluates a scenario, returning the plan
+//	GET  /status         the top-level model, and any error reloading
+//
+// The graph can be replaced while serving, see SetGraph.
+type Server struct {
+	lock    sync.RWMutex
+	graph   *models.Graph
+	pricing *models.Pricing
+	loadErr error
+	mux     *http.ServeMux
+}
+
+// A scenario to evaluate. Inputs and overrides are numbers, or
+// strings holding expressions. Overrides are keyed "model.variable".
+type ScenarioRequest struct {
+	Inputs    map[string]interface{} `json:"inputs"`
+	Overrides map[string]interface{} `json:"overrides"`
+}
+
+// An output of one model feeding an input of another
+type Edge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Input string `json:"input"`
+}
+
+// The dependency graph of the models
+type GraphResponse struct {
+	Top    string     `json:"top"`
+	Models []string   `json:"models"`
+	Edges  []Edge     `json:"edges"`
+	Layers [][]string `json:"layers"`
+}
+
+// The top-level model, and the names of all models
+type ModelsResponse struct {
+	Top    string   `json:"top"`
+	Models []string `json:"models"`
+}
+
+// The top-level model being served, and the error from the last
+// attempt to reload the models, if it failed.
+type StatusResponse struct {
+	Top       string `json:"top"`
+	LoadError string `json:"load_error,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Creates a server for a graph of models. With pricing, plans include
+// monthly costs.
+func New(g *models.Graph, pricing *models.Pricing) *Server {
+	s := Server{graph: g, pricing: pricing, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/models", s.listModels)
+	s.mux.HandleFunc("/models/", s.getModel)
+	s.mux.HandleFunc("/graph", s.getGraph)
+	s.mux.HandleFunc("/plan", s.postPlan)
+	s.mux.HandleFunc("/status", s.getStatus)
+	s.mux.HandleFunc("/", s.getUI)
+	return &s
+}
+
+// Replaces the graph being served. Requests already being handled
+// finish with the graph they started with.
+func (s *Server) SetGraph(g *models.Graph) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.graph = g
+	s.loadErr = nil
+}
+
+// Records a failure to reload the models, reported by /status. The
+// last good graph is still served.
+func (s *Server) SetLoadError(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.loadErr = err
+}
+
+// Returns the graph currently served
+func (s *Server) current() *models.Graph {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.graph
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Writes a value as JSON. It is encoded before anything is written,
+// so a value that cannot be encoded is an error, not an empty body.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errors.New(fmt.Sprintf("Cannot encode the response, %s", err)))
+		return
+	}
+	writeEncoded(w, status, data)
+}
+
+func writeEncoded(w http.ResponseWriter, status int, data []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{err.Error()})
+}
+
+// Checks the method of a request, writing an error if it is wrong.
+func allowed(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, errors.New(fmt.Sprintf("Method %s not allowed", r.Method)))
+		return false
+	}
+	return true
+}
+
+func (s *Server) listModels(w http.ResponseWriter, r *http.Request) {
+	if !allowed(w, r, http.MethodGet) {
+		return
+	}
+	g := s.current()
+	writeJSON(w, http.StatusOK, ModelsResponse{g.Top(), g.Names()})
+}
+
+func (s *Server) getModel(w http.ResponseWriter, r *http.Request) {
+	if !allowed(w, r, http.MethodGet) {
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/models/")
+	m, ok := s.current().Model(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New(fmt.Sprintf("No model named %s", name)))
+		return
+	}
+	writeJSON(w, http.StatusOK, models.ExternalFromModel(m))
+}
+
+func (s *Server) getGraph(w http.ResponseWriter, r *http.Request) {
+	if !allowed(w, r, http.MethodGet) {
+		return
+	}
+	g := s.current()
+	rv := GraphResponse{Top: g.Top(), Models: g.Names(), Edges: []Edge{}, Layers: g.Layers()}
+	for _, name := range rv.Models {
+		m, _ := g.Model(name)
+		for _, o := range m.Outputs {
+			rv.Edges = append(rv.Edges, Edge{name, o.Backend(), o.Input()})
+		}
+	}
+	writeJSON(w, http.StatusOK, rv)
+}
+
+// Converts a JSON value, a number or an expression, to an expression.
+func expression(v interface{}) (models.Expression, error) {
+	switch x := v.(type) {
+	case float64:
+		return models.Parse(strconv.FormatFloat(x, 'f', -1, 64))
+	case string:
+		return models.Parse(x)
+	}
+	return nil, errors.New(fmt.Sprintf("Expected a number or an expression, saw %v", v))
+}
+
+// Converts the inputs and overrides of a request to a scenario.
+func (req ScenarioRequest) scenario() (models.Scenario, error) {
+	rv := models.Scenario{Inputs: make(map[string]models.Expression), Overrides: make(map[string]models.Expression)}
+	for name, v := range req.Inputs {
+		e, err := expression(v)
+		if err != nil {
+			return rv, errors.New(fmt.Sprintf("Bad input %s, %s", name, err))
+		}
+		rv.Inputs[name] = e
+	}
+	for target, v := range req.Overrides {
+		e, err := expression(v)
+		if err != nil {
+			return rv, errors.New(fmt.Sprintf("Bad override %s, %s", target, err))
+		}
+		rv.Overrides[target] = e
+	}
+	return rv, nil
+}
+
+func (s *Server) postPlan(w http.ResponseWriter, r *http.Request) {
+	if !allowed(w, r, http.MethodPost) {
+		return
+	}
+	var req ScenarioRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	scenario, err := req.scenario()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	e, err := s.current().Run(scenario)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// Dividing by a variable overridden to 0, or a range including 0,
+	// gives infinite values, which JSON cannot hold
+	data, err := json.Marshal(models.NewPlan(e, s.pricing))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New(fmt.Sprintf("The plan has values that are not finite, %s", err)))
+		return
+	}
+	writeEncoded(w, http.StatusOK, data)
+}
+
+func (s *Server) getStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowed(w, r, http.MethodGet) {
+		return
+	}
+	s.lock.RLock()
+	rv := StatusResponse{Top: s.graph.Top()}
+	if s.loadErr != nil {
+		rv.LoadError = s.loadErr.Error()
+	}
+	s.lock.RUnlock()
+	writeJSON(w, http.StatusOK, rv)
+}
+
+func (s *Server) getUI(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, errors.New(fmt.Sprintf("No such page %s", r.URL.Path)))
+		return
+	}
+	if !allowed(w, r, http.MethodGet) {
+		return
+	}
+	data, err := ui.ReadFile("ui/index.html")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(data)
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vatine/planning/models"
+)
+
+const testModels = `- name: top
+  inputs:
+   - qps
+  outputs:
+   - backend: back
+     input: qps
+     expression: qps * 2
+- name: back
+  inputs:
+   - qps
+  variables:
+   per_replica: 100
+  resources:
+   replicas: qps / per_replica
+   cpu: 2
+`
+
+func testGraph(t *testing.T, data string) *models.Graph {
+	ext, err := models.LoadExternalModels(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	g, err := models.GraphFromExternal(ext, "top")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	return g
+}
+
+func testServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(New(testGraph(t, testModels), nil))
+}
+
+// Makes a request, checks the status and decodes the response.
+func request(t *testing.T, method, url, body string, status int, v interface{}) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		t.Errorf("%s %s, saw status %d, expected %d", method, url, resp.StatusCode, status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Errorf("%s %s, failed to decode response, %s", method, url, err)
+	}
+}
+
+func TestModels(t *testing.T) {
+	ts := testServer(t)
+	defer ts.Close()
+
+	var list ModelsResponse
+	request(t, "GET", ts.URL+"/models", "", http.StatusOK, &list)
+	if list.Top != "top" || len(list.Models) != 2 || list.Models[0] != "back" {
+		t.Errorf("Unexpected model list %v", list)
+	}
+
+	var m models.ExternalModel
+	request(t, "GET", ts.URL+"/models/back", "", http.StatusOK, &m)
+	if m.Name != "back" || m.Variables["per_replica"] != "100" || m.Resources["replicas"] != "qps / per_replica" {
+		t.Errorf("Unexpected model %v", m)
+	}
+
+	var e errorResponse
+	request(t, "GET", ts.URL+"/models/nope", "", http.StatusNotFound, &e)
+	if e.Error == "" {
+		t.Errorf("Expected an error message")
+	}
+	request(t, "POST", ts.URL+"/models", "", http.StatusMethodNotAllowed, &e)
+}
+
+func TestGraph(t *testing.T) {
+	ts := testServer(t)
+	defer ts.Close()
+
+	var g GraphResponse
+	request(t, "GET", ts.URL+"/graph", "", http.StatusOK, &g)
+	if len(g.Edges) != 1 || g.Edges[0] != (Edge{"top", "back", "qps"}) {
+		t.Errorf("Unexpected edges %v", g.Edges)
+	}
+	if len(g.Layers) != 2 || g.Layers[0][0] != "top" || g.Layers[1][0] != "back" {
+		t.Errorf("Unexpected layers %v", g.Layers)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	ts := testServer(t)
+	defer ts.Close()
+
+	td := []struct {
+		body     string
+		status   int
+		replicas float64
+	}{
+		{`{"inputs": {"qps": 500}}`, http.StatusOK, 10},
+		{`{"inputs": {"qps": "400..600"}}`, http.StatusOK, 10},
+		{`{"inputs": {"qps": 500}, "overrides": {"back.per_replica": 50}}`, http.StatusOK, 20},
+		{`{"inputs": {"qps": 500}, "overrides": {"back.nope": 50}}`, http.StatusBadRequest, 0},
+		{`{"inputs": {"qps": 500}, "overrides": {"back.per_replica": 0}}`, http.StatusBadRequest, 0},
+		{`{"inputs": {"qps": 500}, "overrides": {"back.per_replica": "0..100"}}`, http.StatusBadRequest, 0},
+		{`{"inputs": {"qps": "500 +"}}`, http.StatusBadRequest, 0},
+		{`{"inputs": {"qps": true}}`, http.StatusBadRequest, 0},
+		{`not json`, http.StatusBadRequest, 0},
+	}
+
+	for ix, d := range td {
+		if d.status != http.StatusOK {
+			var e errorResponse
+			request(t, "POST", ts.URL+"/plan", d.body, d.status, &e)
+			if e.Error == "" {
+				t.Errorf("test %d, expected an error message", ix)
+			}
+			continue
+		}
+		var p models.Plan
+		request(t, "POST", ts.URL+"/plan", d.body, d.status, &p)
+		if len(p.Models) != 2 || p.Models[0].Name != "back" || p.Models[0].Replicas != d.replicas {
+			t.Errorf("test %d, unexpected plan %v", ix, p)
+		}
+		if p.CPU != 2*d.replicas {
+			t.Errorf("test %d, saw total cpu %f, expected %f", ix, p.CPU, 2*d.replicas)
+		}
+	}
+}
+
+func TestPlanCost(t *testing.T) {
+	ts := httptest.NewServer(New(testGraph(t, testModels), &models.Pricing{CPUCoreHour: 0.5}))
+	defer ts.Close()
+
+	var p models.Plan
+	request(t, "POST", ts.URL+"/plan", `{"inputs": {"qps": 500}}`, http.StatusOK, &p)
+	// 10 replicas of 2 cores
+	if p.Cost == nil || p.Cost.CPU != 20*0.5*models.HoursPerMonth || p.Models[0].Cost == nil {
+		t.Errorf("Unexpected cost in plan %v", p)
+	}
+}
+
+func TestReload(t *testing.T) {
+	s := New(testGraph(t, testModels), nil)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	s.SetLoadError(errors.New("bad model"))
+	var status StatusResponse
+	request(t, "GET", ts.URL+"/status", "", http.StatusOK, &status)
+	if status.Top != "top" || status.LoadError != "bad model" {
+		t.Errorf("Unexpected status %v", status)
+	}
+	var list ModelsResponse
+	request(t, "GET", ts.URL+"/models", "", http.StatusOK, &list)
+	if len(list.Models) != 2 {
+		t.Errorf("Expected the last good models after a failed reload, saw %v", list)
+	}
+
+	s.SetGraph(testGraph(t, "- name: top\n  inputs:\n   - qps\n"))
+	request(t, "GET", ts.URL+"/models", "", http.StatusOK, &list)
+	if len(list.Models) != 1 {
+		t.Errorf("Expected the reloaded models, saw %v", list)
+	}
+	status = StatusResponse{}
+	request(t, "GET", ts.URL+"/status", "", http.StatusOK, &status)
+	if status.LoadError != "" {
+		t.Errorf("Expected no load error after reloading, saw %s", status.LoadError)
+	}
+}
+
+func TestReloadBadExpression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "planning")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "top.yaml")
+	bad := strings.Replace(testModels, "replicas: qps / per_replica", "replicas: qps /", 1)
+	if err := ioutil.WriteFile(filename, []byte(bad), 0644); err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+
+	s := New(testGraph(t, testModels), nil)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	if _, err := models.LoadGraph(filename); err == nil {
+		t.Fatalf("Expected an error loading a bad expression")
+	} else {
+		s.SetLoadError(err)
+	}
+
+	var status StatusResponse
+	request(t, "GET", ts.URL+"/status", "", http.StatusOK, &status)
+	if !strings.Contains(status.LoadError, "bad resource replicas") {
+		t.Errorf("Expected the bad expression in the status, saw %v", status)
+	}
+	var m models.ExternalModel
+	request(t, "GET", ts.URL+"/models/back", "", http.StatusOK, &m)
+	if m.Resources["replicas"] != "qps / per_replica" {
+		t.Errorf("Expected the last good model, saw %v", m)
+	}
+}
+
+func TestUI(t *testing.T) {
+	ts := testServer(t)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("Saw status %d, content type %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	for _, endpoint := range []string{"/graph", "/plan", "/models/"} {
+		if !strings.Contains(string(body), endpoint) {
+			t.Errorf("Expected the page to use %s", endpoint)
+		}
+	}
+
+	var e errorResponse
+	request(t, "GET", ts.URL+"/nope", "", http.StatusNotFound, &e)
+}
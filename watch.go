@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/vatine/planning/models"
+)
+
+// Returns a function printing the plan for a freshly loaded graph, or
+// the error if it failed to load.
+func planReporter(w io.Writer, filename string, inputs map[string]models.Expression, pricing *models.Pricing) func(*models.Graph, error) {
+	return func(g *models.Graph, err error) {
+		fmt.Fprintf(w, "# %s, %s\n", filename, time.Now().Format(time.RFC3339))
+		if err != nil {
+			fmt.Fprintf(w, "Failed to load models, keeping the last good version, %s\n", err)
+			return
+		}
+		usage, err := g.Evaluate(inputs)
+		if err != nil {
+			fmt.Fprintf(w, "Failed to propagate, %s\n", err)
+			return
+		}
+		models.PrintModels(w, usage, pricing)
+	}
+}
+
+// Prints the plan for a graph, and prints it again whenever the model
+// files change, until interrupted. If the changed files fail to load,
+// the error is printed and the last good plan stands.
+func watchModels(filename string, watcher *models.Watcher, poll time.Duration, g *models.Graph, err error, inputs map[string]models.Expression, pricing *models.Pricing, policy *models.Policy) {
+	report := planReporter(os.Stdout, filename, inputs, pricing)
+	report(g, err)
+	if watcher != nil {
+		watcher.Run(context.Background(), poll, func(g *models.Graph, err error) {
+			report(applyPolicy(policy, g, err))
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vatine/planning/models"
+)
+
+func TestPlanReporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "planning")
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "top.yaml")
+
+	var buf bytes.Buffer
+	report := planReporter(&buf, filename, map[string]models.Expression{}, nil)
+
+	ioutil.WriteFile(filename, []byte("- name: top\n  inputs:\n   - qps\n  resources:\n   replicas: qps / 100\n"), 0644)
+	report(models.LoadGraph(filename))
+	if !strings.Contains(buf.String(), "- name: top\n") {
+		t.Errorf("Expected the plan, saw\n%s", buf.String())
+	}
+
+	// A typo in an expression fails the reload, rather than dropping the
+	// resource
+	buf.Reset()
+	ioutil.WriteFile(filename, []byte("- name: top\n  inputs:\n   - qps\n  resources:\n   replicas: qps /\n"), 0644)
+	report(models.LoadGraph(filename))
+	if !strings.Contains(buf.String(), "Failed to load models, keeping the last good version, Model top, bad resource replicas") {
+		t.Errorf("Expected the load error, saw\n%s", buf.String())
+	}
+}